@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/aelnor/vangothrone/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+// evaluateAchievements brings achievements of all users in the season up to date with its matches.
+func evaluateAchievements(db *sql.DB, seasonId int64) error {
+	added, revoked, err := models.EvaluateAchievements(db, seasonId)
+	if err != nil {
+		return err
+	}
+	for _, a := range added {
+		log.Printf("Achievement awarded: %+v", a)
+	}
+	for _, a := range revoked {
+		log.Printf("Achievement taken back: %+v", a)
+	}
+	return nil
+}
+
+// GetAchievements lists all achievements along with the users who earned them.
+func (h *HttpHandlers) GetAchievements(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	awards, err := models.LoadAwards(h.Env.DB)
+	if err != nil {
+		log.Print("Can't load awards: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load achievements"}, http.StatusInternalServerError)
+		return
+	}
+
+	type achievement struct {
+		*models.Achievement
+		Awards []*models.Award `json:"awards"`
+	}
+	result := make([]*achievement, 0, len(models.Achievements))
+	byCode := make(map[string]*achievement)
+	for _, a := range models.Achievements {
+		elem := &achievement{Achievement: a, Awards: make([]*models.Award, 0)}
+		result = append(result, elem)
+		byCode[a.Code] = elem
+	}
+	for _, a := range awards {
+		if elem, ok := byCode[a.Code]; ok {
+			elem.Awards = append(elem.Awards, a)
+		}
+	}
+
+	if err := respondWithJson(w, r, result); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
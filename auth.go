@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aelnor/vangothrone/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+type role int
+
+const (
+	roleAnonymous role = iota
+	roleUser
+	roleAdmin
+)
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	sessionContextKey
+)
+
+// authorize wraps a handler so it is only reached by requests of the required
+// role. The logged in user, if any, is put into the request context and is
+// available to the handler through currentUser.
+func (h *HttpHandlers) authorize(required role, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		user, session, err := initSession(h.Env.DB, r)
+		if err == nil {
+			ctx := context.WithValue(r.Context(), userContextKey, user)
+			ctx = context.WithValue(ctx, sessionContextKey, session)
+			r = r.WithContext(ctx)
+		}
+
+		if required >= roleUser && user == nil {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Not logged in"}, http.StatusUnauthorized)
+			return
+		}
+
+		if required >= roleAdmin && !user.IsAdmin {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Admin rights required"}, http.StatusForbidden)
+			return
+		}
+
+		handle(w, r, p)
+	}
+}
+
+// currentUser returns the user authorized for the request, nil for anonymous requests.
+func currentUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userContextKey).(*models.User)
+	return user
+}
+
+func currentSession(r *http.Request) *models.Session {
+	session, _ := r.Context().Value(sessionContextKey).(*models.Session)
+	return session
+}
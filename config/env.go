@@ -2,6 +2,7 @@ package config
 
 import (
 	"database/sql"
+	"os"
 )
 
 type Env struct {
@@ -11,3 +12,9 @@ type Env struct {
 func GetStaticPath() string {
 	return "/var/vangothrone/"
 }
+
+// GetSecureCookies tells whether session cookies are restricted to HTTPS.
+// Set VANGOTHRONE_INSECURE_COOKIES to serve them over plain HTTP, e.g. locally.
+func GetSecureCookies() bool {
+	return len(os.Getenv("VANGOTHRONE_INSECURE_COOKIES")) == 0
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aelnor/vangothrone/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+func respondWithMarketError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case sql.ErrNoRows:
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Market is not found"}, http.StatusNotFound)
+	default:
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusBadRequest)
+	}
+}
+
+func marketId(w http.ResponseWriter, p httprouter.Params) (int64, bool) {
+	paramId := p.ByName("id")
+	id, err := strconv.ParseInt(paramId, 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		log.Printf("Bad market id: %s", paramId)
+		return 0, false
+	}
+	return id, true
+}
+
+// attachPicks puts the picks into their markets as the viewer may see them.
+// Picks of other users are hidden until the deadline. If members isn't nil,
+// picks of users outside of it are left out.
+func attachPicks(markets []*models.Market, picks []*models.FuturePick, v *visibility, members map[int64]bool) {
+	marketsMap := make(map[int64]*models.Market)
+	for _, m := range markets {
+		m.Picks = make([]*models.FuturePick, 0)
+		marketsMap[m.Id] = m
+	}
+
+	for _, elem := range picks {
+		market, ok := marketsMap[elem.MarketId]
+		if !ok {
+			continue
+		}
+		if members != nil && !members[elem.UserId] {
+			continue
+		}
+		if pick := v.pick(elem, market.IsPastDeadline()); pick != nil {
+			market.Picks = append(market.Picks, pick)
+		}
+	}
+}
+
+func (h *HttpHandlers) GetFutures(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	season, err := requestedSeason(w, r, h.Env.DB)
+	if err != nil {
+		log.Print("Can't scope futures: ", err)
+		return
+	}
+
+	members, err := leagueMembers(w, r, h.Env.DB)
+	if err != nil {
+		log.Print("Can't scope futures: ", err)
+		return
+	}
+
+	markets, err := models.LoadMarketsBySeason(h.Env.DB, season.Id)
+	if err != nil {
+		log.Print("Can't load markets: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load markets"}, http.StatusInternalServerError)
+		return
+	}
+
+	picks, err := models.LoadPicksBySeason(h.Env.DB, season.Id)
+	if err != nil {
+		log.Print("Can't load picks: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load picks"}, http.StatusInternalServerError)
+		return
+	}
+
+	v, err := newVisibility(h.Env.DB, currentUser(r))
+	if err != nil {
+		log.Print("Can't load leagues: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load picks"}, http.StatusInternalServerError)
+		return
+	}
+	attachPicks(markets, picks, v, members)
+
+	if err := respondWithJson(w, r, markets); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
+
+func (h *HttpHandlers) PostFutures(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var jsonMarket struct {
+		Name     string    `json:"name"`
+		SeasonId int64     `json:"seasonId"`
+		StageId  int64     `json:"stageId"`
+		Deadline time.Time `json:"deadline"`
+		Points   int       `json:"points"`
+	}
+
+	if err := processBody(w, r, &jsonMarket); err != nil {
+		log.Printf("Can't process market adding: %v", err)
+		return
+	}
+
+	if jsonMarket.SeasonId == 0 && jsonMarket.StageId == 0 {
+		season, err := models.GetCurrentSeason(h.Env.DB)
+		if err != nil {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "There is no current season"}, http.StatusBadRequest)
+			return
+		}
+		jsonMarket.SeasonId = season.Id
+	}
+
+	m := &models.Market{
+		Name:     jsonMarket.Name,
+		SeasonId: jsonMarket.SeasonId,
+		StageId:  jsonMarket.StageId,
+		Deadline: jsonMarket.Deadline,
+		Points:   jsonMarket.Points,
+	}
+	if err := models.AddMarket(h.Env.DB, m); err != nil {
+		log.Printf("Can't add market: %v", err)
+		respondWithMarketError(w, r, err)
+		return
+	}
+
+	respondWithJsonAndStatus(w, r, &requestResult{Status: "OK", Id: m.Id}, http.StatusCreated)
+	log.Printf("Market added: %+v", m)
+}
+
+func (h *HttpHandlers) PutFuturePick(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, ok := marketId(w, p)
+	if !ok {
+		return
+	}
+
+	var jsonPick struct {
+		Team string `json:"team"`
+	}
+
+	if err := processBody(w, r, &jsonPick); err != nil {
+		log.Printf("Can't process pick: %v", err)
+		return
+	}
+
+	pick := &models.FuturePick{
+		UserId:   currentUser(r).Id,
+		MarketId: id,
+		Team:     jsonPick.Team,
+	}
+	if err := models.SavePick(h.Env.DB, pick); err != nil {
+		log.Printf("Can't save pick: %v", err)
+		respondWithMarketError(w, r, err)
+		return
+	}
+
+	respondWithJsonAndStatus(w, r, &requestResult{Status: "OK"}, http.StatusCreated)
+	log.Printf("Saved pick: %+v", pick)
+}
+
+func (h *HttpHandlers) PutFutureSettle(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, ok := marketId(w, p)
+	if !ok {
+		return
+	}
+
+	var jsonSettle struct {
+		Winner string `json:"winner"`
+	}
+
+	if err := processBody(w, r, &jsonSettle); err != nil {
+		log.Printf("Can't process market settling: %v", err)
+		return
+	}
+
+	if err := models.SettleMarket(h.Env.DB, id, jsonSettle.Winner); err != nil {
+		log.Printf("Can't settle market: %v", err)
+		respondWithMarketError(w, r, err)
+		return
+	}
+
+	respondWithJson(w, r, &requestResult{Status: "OK"})
+	log.Printf("Market %d settled: %s", id, jsonSettle.Winner)
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
 	"sync"
@@ -20,14 +22,18 @@ type HttpHandlers struct {
 	Env *config.Env
 }
 
+// cache keeps matches of the current stage of the current season and
+// predictions for them. Stats of any match are kept along with predictions.
 type cache struct {
 	matchesMx sync.Mutex
 	matches   []*models.Match
+	season    *models.Season
 	stage     *models.Stage
 	cacheTime time.Time
 
 	predictionsMx sync.Mutex
 	predictions   []*models.Prediction
+	stats         map[int64]*models.MatchStats
 }
 
 var cached cache
@@ -43,17 +49,29 @@ func (c *cache) Matches(db *sql.DB) ([]*models.Match, error) {
 		return c.matches, nil
 	}
 
-	stage, err := models.GetCurrentStage(db)
+	season, err := models.GetCurrentSeason(db)
 	if err != nil {
 		return nil, err
 	}
-	matches, err := models.LoadMatchesByStage(db, stage)
-	if err != nil {
+	// between stages there is just nothing to show
+	matches := make([]*models.Match, 0)
+	stage, err := models.GetCurrentStage(db, season.Id)
+	switch {
+	case err == nil:
+		matches, err = models.LoadMatchesByStage(db, stage)
+		if err != nil {
+			return nil, err
+		}
+	case err == sql.ErrNoRows:
+		stage = nil
+	default:
 		return nil, err
 	}
 	c.matches = matches
+	c.season = season
 	c.stage = stage
 	c.cacheTime = time.Now()
+	c.InvalidatePredictions()
 
 	return matches, nil
 }
@@ -86,9 +104,45 @@ func (c *cache) InvalidateMatches() {
 	c.matchesMx.Unlock()
 }
 
+// MatchStats returns crowd stats of the match. Once predictions are locked
+// the stats are computed on first request and kept. Before that they depend
+// on leagues hiding predictors, which may change any time, so they aren't kept.
+func (c *cache) MatchStats(db *sql.DB, m *models.Match) (*models.MatchStats, error) {
+	locked := m.IsLocked()
+
+	c.predictionsMx.Lock()
+	defer c.predictionsMx.Unlock()
+
+	if stats, ok := c.stats[m.Id]; ok && locked {
+		return stats, nil
+	}
+
+	predictions, err := models.LoadPredictionsByMatch(db, m.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !locked {
+		hidden, err := models.LoadHiddenPredictors(db)
+		if err != nil {
+			return nil, err
+		}
+		return models.ComputeOpenMatchStats(m.Id, predictions, hidden), nil
+	}
+
+	if c.stats == nil {
+		c.stats = make(map[int64]*models.MatchStats)
+	}
+	stats := models.ComputeMatchStats(m.Id, predictions)
+	c.stats[m.Id] = stats
+
+	return stats, nil
+}
+
 func (c *cache) InvalidatePredictions() {
 	c.predictionsMx.Lock()
 	c.predictions = nil
+	c.stats = nil
 	c.predictionsMx.Unlock()
 }
 
@@ -112,7 +166,7 @@ func respondWithJson(w http.ResponseWriter, r *http.Request, data interface{}) e
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, string(jsontext))
+	w.Write(jsontext)
 
 	return nil
 }
@@ -127,7 +181,7 @@ func respondWithJsonAndStatus(w http.ResponseWriter, r *http.Request, data inter
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	fmt.Fprintf(w, string(jsontext))
+	w.Write(jsontext)
 
 	return nil
 }
@@ -146,64 +200,166 @@ func processBody(w http.ResponseWriter, r *http.Request, result interface{}) err
 	return nil
 }
 
-func initUser(db *sql.DB, r *http.Request) (*models.User, error) {
-	login, err := r.Cookie("Login")
-	password, errP := r.Cookie("Password")
-	if err != nil || errP != nil {
-		return nil, fmt.Errorf("Not logged in")
+const sessionCookie = "Session"
+
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
+	return host
+}
 
-	return models.LoadUser(db, login.Value, password.Value)
+func expireCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:    name,
+		Path:    "/",
+		MaxAge:  -1,
+		Expires: time.Now().Add(-time.Hour * 24),
+	})
 }
 
-func getMatches(db *sql.DB) ([]*models.Match, error) {
-	matches, err := cached.Matches(db)
-	if err != nil {
-		return nil, err
+func initSession(db *sql.DB, r *http.Request) (*models.User, *models.Session, error) {
+	token, err := r.Cookie(sessionCookie)
+	if err != nil || len(token.Value) == 0 {
+		return nil, nil, fmt.Errorf("Not logged in")
 	}
+
+	return models.LoadSessionUser(db, token.Value)
+}
+
+func initUser(db *sql.DB, r *http.Request) (*models.User, error) {
+	user, _, err := initSession(db, r)
+	return user, err
+}
+
+func copyMatches(matches []*models.Match) []*models.Match {
 	matchesCopy := make([]*models.Match, len(matches))
 
 	for i, elem := range matches {
 		matchesCopy[i] = new(models.Match)
 		*matchesCopy[i] = *elem
 	}
-	return matchesCopy, nil
+	return matchesCopy
 }
 
-func (h *HttpHandlers) GetMatches(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	user, err := initUser(h.Env.DB, r)
+func getMatches(db *sql.DB) ([]*models.Match, []*models.Prediction, error) {
+	matches, err := cached.Matches(db)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-		return
+		return nil, nil, err
 	}
 
-	matches, err := getMatches(h.Env.DB)
+	predictions, err := cached.Predictions(db)
 	if err != nil {
-		respondWithJsonAndStatus(w, r, &requestResult{Status: "Can't load matches"}, http.StatusInternalServerError)
-		log.Print("Can't load matches: ", err)
-		return
+		return nil, nil, err
+	}
+	return copyMatches(matches), predictions, nil
+}
+
+// getSeasonMatches loads matches of the current stage of the season, or of
+// the whole season when none of its stages is running, e.g. for archived seasons.
+func getSeasonMatches(db *sql.DB, season *models.Season) ([]*models.Match, []*models.Prediction, error) {
+	var matches []*models.Match
+	stage, err := models.GetCurrentStage(db, season.Id)
+	switch {
+	case err == nil:
+		matches, err = models.LoadMatchesByStage(db, stage)
+	case err == sql.ErrNoRows:
+		matches, err = models.LoadMatchesBySeason(db, season.Id)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	predictions, err := models.LoadPredictionsByMatches(db, matches)
+	if err != nil {
+		return nil, nil, err
 	}
+	return matches, predictions, nil
+}
 
-	ids := make([]int64, len(matches))
+// attachPredictions puts the predictions into their matches as the viewer
+// may see them. Predictions of other users are hidden until predictions for
+// the match lock. If members isn't nil, predictions of users outside of it
+// are left out.
+func attachPredictions(matches []*models.Match, predictions []*models.Prediction, v *visibility, members map[int64]bool) {
 	matchesMap := make(map[int64]*models.Match)
-	for i, el := range matches {
-		ids[i] = el.Id
+	for _, el := range matches {
 		matchesMap[el.Id] = el
 	}
 
-	predictions, err := cached.Predictions(h.Env.DB)
+	for _, elem := range predictions {
+		match, ok := matchesMap[elem.MatchId]
+		if !ok {
+			continue
+		}
+		if members != nil && !members[elem.UserId] {
+			continue
+		}
+		if pred := v.prediction(elem, match.IsLocked()); pred != nil {
+			match.Predictions = append(match.Predictions, pred)
+		}
+	}
+}
+
+// attachStats puts crowd stats into the matches, the same GET /matches/:id/stats shows.
+func attachStats(matches []*models.Match, predictions []*models.Prediction, v *visibility) {
+	byMatch := make(map[int64][]*models.Prediction)
+	for _, pred := range predictions {
+		byMatch[pred.MatchId] = append(byMatch[pred.MatchId], pred)
+	}
+	for _, m := range matches {
+		m.Stats = models.StatsFor(m, byMatch[m.Id], v.hiddenPredictors)
+	}
+}
+
+func (h *HttpHandlers) GetMatches(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user := currentUser(r)
 
+	members, err := leagueMembers(w, r, h.Env.DB)
 	if err != nil {
-		log.Print("Can't load predictions: ", err)
+		log.Print("Can't scope matches: ", err)
+		return
 	}
 
-	for _, elem := range predictions {
-		pred := *elem
-		if !matchesMap[elem.MatchId].IsStarted() && elem.UserId != user.Id {
-			pred.Score = "0:0"
+	var matches []*models.Match
+	var predictions []*models.Prediction
+	if hasMatchFilter(r) {
+		filter, filterErr := parseMatchFilter(w, r, h.Env.DB)
+		if filterErr != nil {
+			log.Print("Can't filter matches: ", filterErr)
+			return
+		}
+		var total int
+		matches, total, err = models.FindMatches(h.Env.DB, filter)
+		if err == nil {
+			w.Header().Set("X-Total-Count", strconv.Itoa(total))
+			predictions, err = models.LoadPredictionsByMatches(h.Env.DB, matches)
 		}
-		matchesMap[elem.MatchId].Predictions = append(matchesMap[elem.MatchId].Predictions, &pred)
+	} else if len(r.URL.Query().Get("season")) == 0 {
+		matches, predictions, err = getMatches(h.Env.DB)
+	} else {
+		season, seasonErr := requestedSeason(w, r, h.Env.DB)
+		if seasonErr != nil {
+			log.Print("Can't scope matches: ", seasonErr)
+			return
+		}
+		matches, predictions, err = getSeasonMatches(h.Env.DB, season)
+	}
+	if err != nil {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Can't load matches"}, http.StatusInternalServerError)
+		log.Print("Can't load matches: ", err)
+		return
+	}
+
+	v, err := newVisibility(h.Env.DB, user)
+	if err != nil {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Can't load matches"}, http.StatusInternalServerError)
+		log.Print("Can't load leagues: ", err)
+		return
 	}
+	attachPredictions(matches, predictions, v, members)
+	attachStats(matches, predictions, v)
 
 	if err := respondWithJson(w, r, matches); err != nil {
 		log.Print("Can't send response: ", err)
@@ -213,8 +369,10 @@ func (h *HttpHandlers) GetMatches(w http.ResponseWriter, r *http.Request, _ http
 
 func (h *HttpHandlers) PostMatches(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	var jsonMatch struct {
-		Teams [2]string `json:"teams"`
-		Date  time.Time `json:"date"`
+		Teams    [2]string          `json:"teams"`
+		Date     time.Time          `json:"date"`
+		SeasonId int64              `json:"seasonId"`
+		Format   models.MatchFormat `json:"format"`
 	}
 
 	if err := processBody(w, r, &jsonMatch); err != nil {
@@ -222,13 +380,28 @@ func (h *HttpHandlers) PostMatches(w http.ResponseWriter, r *http.Request, _ htt
 		return
 	}
 
+	if jsonMatch.SeasonId == 0 {
+		season, err := models.GetCurrentSeason(h.Env.DB)
+		if err != nil {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "There is no current season"}, http.StatusBadRequest)
+			return
+		}
+		jsonMatch.SeasonId = season.Id
+	}
+
 	m := &models.Match{
-		Teams: jsonMatch.Teams,
-		Date:  jsonMatch.Date,
+		Teams:    jsonMatch.Teams,
+		Date:     jsonMatch.Date,
+		SeasonId: jsonMatch.SeasonId,
+		Format:   jsonMatch.Format,
 	}
 
 	err := models.AddMatch(h.Env.DB, m)
 
+	if _, ok := err.(*models.ScoreError); ok || err == models.ErrUnknownTeam || err == models.ErrSeasonArchived || err == models.ErrResultBeforeLock {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		log.Printf("Can't save match: %v", err)
@@ -241,12 +414,34 @@ func (h *HttpHandlers) PostMatches(w http.ResponseWriter, r *http.Request, _ htt
 	log.Printf("Match added: %+v", jsonMatch)
 }
 
-func (h *HttpHandlers) PutPredictions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	user, err := initUser(h.Env.DB, r)
+func predictionsClosedReason(m *models.Match) string {
+	switch {
+	case m.Status == models.STATUS_CANCELLED:
+		return "Match is cancelled"
+	case m.PredictionsLocked:
+		return "Match is postponed, predictions are locked"
+	case !m.IsStarted():
+		return "Predictions for the match are locked"
+	}
+	return "Match has started already"
+}
+
+// checkPrediction validates a prediction for the match and returns the
+// predicted score. The error explains to the user why the prediction is rejected.
+func checkPrediction(match *models.Match, score string, maps []*models.MapPrediction) (models.Score, error) {
+	if !match.AcceptsPredictions() {
+		return models.Score{}, errors.New(predictionsClosedReason(match))
+	}
+
+	parsed, err := match.Format.ParseScore(score)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-		return
+		return parsed, err
 	}
+	return parsed, match.ValidateMapPredictions(maps)
+}
+
+func (h *HttpHandlers) PutPredictions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user := currentUser(r)
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -256,9 +451,11 @@ func (h *HttpHandlers) PutPredictions(w http.ResponseWriter, r *http.Request, _
 	}
 
 	var jsonPrediction struct {
-		UserId  int64  `json:"userId"`
-		MatchId int64  `json:"matchId"`
-		Score   string `json:"score"`
+		UserId  int64                   `json:"userId"`
+		MatchId int64                   `json:"matchId"`
+		Score   string                  `json:"score"`
+		Maps    []*models.MapPrediction `json:"maps"`
+		Joker   *bool                   `json:"joker"`
 	}
 
 	err = json.Unmarshal(body, &jsonPrediction)
@@ -275,18 +472,24 @@ func (h *HttpHandlers) PutPredictions(w http.ResponseWriter, r *http.Request, _
 		return
 	}
 
-	if match.IsStarted() {
-		log.Printf("Trying to post predictions to an already started match: %+v", match)
-		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Match has started already"}, http.StatusBadRequest)
+	score, err := checkPrediction(match, jsonPrediction.Score, jsonPrediction.Maps)
+	if err != nil {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusBadRequest)
 		return
 	}
 
-	err = models.SavePrediction(h.Env.DB, &models.Prediction{
+	err = models.SavePredictionWithJoker(h.Env.DB, &models.Prediction{
 		UserId:  user.Id,
 		MatchId: jsonPrediction.MatchId,
-		Score:   jsonPrediction.Score,
-	})
+		Score:   score.String(),
+		Maps:    jsonPrediction.Maps,
+		Ip:      clientIp(r),
+	}, match, jsonPrediction.Joker)
 
+	if err == models.ErrJokerNoStage || err == models.ErrJokerLocked {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		log.Printf("Can't save prediction: %v", err)
@@ -308,9 +511,13 @@ func (h *HttpHandlers) PutMatch(w http.ResponseWriter, r *http.Request, p httpro
 	}
 
 	var jsonMatch struct {
-		Teams  [2]string `json:"teams"`
-		Date   time.Time `json:"date"`
-		Result string    `json:"result"`
+		Teams             [2]string           `json:"teams"`
+		Date              time.Time           `json:"date"`
+		Result            string              `json:"result"`
+		Status            string              `json:"status"`
+		ReopenPredictions bool                `json:"reopenPredictions"`
+		Format            *models.MatchFormat `json:"format"`
+		Maps              []*models.MatchMap  `json:"maps"`
 	}
 
 	if err = processBody(w, r, &jsonMatch); err != nil {
@@ -318,8 +525,41 @@ func (h *HttpHandlers) PutMatch(w http.ResponseWriter, r *http.Request, p httpro
 		return
 	}
 
-	err = models.SaveMatch(h.Env.DB, &models.Match{Id: id, Teams: jsonMatch.Teams, Date: jsonMatch.Date, Result: jsonMatch.Result})
+	var format models.MatchFormat
+	if jsonMatch.Format != nil {
+		format = *jsonMatch.Format
+		if format.BestOf == 0 {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Format should have the number of maps"}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if len(jsonMatch.Status) != 0 {
+		if err := models.ValidateMatchStatus(jsonMatch.Status); err != nil {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = models.SaveMatch(h.Env.DB, &models.Match{
+		Id:                id,
+		Teams:             jsonMatch.Teams,
+		Date:              jsonMatch.Date,
+		Result:            jsonMatch.Result,
+		Status:            jsonMatch.Status,
+		PredictionsLocked: !jsonMatch.ReopenPredictions,
+		Format:            format,
+		Maps:              jsonMatch.Maps,
+	})
 
+	if _, ok := err.(*models.ScoreError); ok || err == models.ErrUnknownTeam || err == models.ErrSeasonArchived || err == models.ErrResultBeforeLock {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err == sql.ErrNoRows {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Match is not found"}, http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		log.Printf("Can't save match: %v", err)
@@ -327,10 +567,78 @@ func (h *HttpHandlers) PutMatch(w http.ResponseWriter, r *http.Request, p httpro
 	}
 
 	cached.InvalidateMatches()
+
+	// cancelling a match voids its predictions, so the status matters for scoring too
+	if len(jsonMatch.Result) != 0 || len(jsonMatch.Status) != 0 || jsonMatch.Format != nil || jsonMatch.Maps != nil {
+		match, err := models.LoadMatch(h.Env.DB, id)
+		if err == nil {
+			err = models.ScoreMatch(h.Env.DB, match)
+		}
+		cached.InvalidatePredictions()
+		if err != nil {
+			log.Printf("Can't score match %d: %v", id, err)
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Match is saved, but points can't be updated"}, http.StatusInternalServerError)
+			return
+		}
+
+		// a corrected result or a cancellation may take achievements back
+		if err := evaluateAchievements(h.Env.DB, match.SeasonId); err != nil {
+			log.Printf("Can't evaluate achievements: %v", err)
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Match is saved, but achievements can't be updated"}, http.StatusInternalServerError)
+			return
+		}
+	}
 	respondWithJson(w, r, &requestResult{Status: "OK"})
 	log.Printf("Match saved: %+v", jsonMatch)
 }
 
+func (h *HttpHandlers) DeleteMatch(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	paramId := p.ByName("id")
+	id, err := strconv.ParseInt(paramId, 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		log.Printf("Bad match id: %s", paramId)
+		return
+	}
+
+	// the season is needed to update achievements once the match is gone
+	match, err := models.LoadMatch(h.Env.DB, id)
+	if err == sql.ErrNoRows {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Match is not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		log.Printf("Can't load match: %v", err)
+		return
+	}
+
+	cascade := r.URL.Query().Get("cascade") == "true"
+	err = models.DeleteMatch(h.Env.DB, id, cascade)
+	switch {
+	case err == sql.ErrNoRows:
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Match is not found"}, http.StatusNotFound)
+		return
+	case err == models.ErrMatchHasPredictions:
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Match has predictions, use cascade=true to delete them too"}, http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		log.Printf("Can't delete match: %v", err)
+		return
+	}
+
+	cached.InvalidateMatches()
+	cached.InvalidatePredictions()
+	if err := evaluateAchievements(h.Env.DB, match.SeasonId); err != nil {
+		log.Printf("Can't evaluate achievements: %v", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Match is deleted, but achievements can't be updated"}, http.StatusInternalServerError)
+		return
+	}
+	respondWithJson(w, r, &requestResult{Status: "OK"})
+	log.Printf("Match deleted: %d, cascade: %v", id, cascade)
+}
+
 func (h *HttpHandlers) PostLogin(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	var jsonUser struct {
 		Login    string `json:"login"`
@@ -349,21 +657,31 @@ func (h *HttpHandlers) PostLogin(w http.ResponseWriter, r *http.Request, p httpr
 		return
 	}
 
-	_, err := models.CheckCredentials(h.Env.DB, jsonUser.Login, jsonUser.Password)
+	user, err := models.CheckCredentials(h.Env.DB, jsonUser.Login, jsonUser.Password)
 	if err != nil {
 		respondWithJson(w, r, &requestResult{Status: "Failed", Text: "Incorrect user or password"})
 		return
 	}
 
+	session := &models.Session{UserId: user.Id, UserAgent: r.UserAgent(), Ip: clientIp(r)}
+	if err := models.CreateSession(h.Env.DB, session); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		log.Printf("Can't create session: %v", err)
+		return
+	}
+
+	// cookies of the times when the password hash was the credential
+	expireCookie(w, "Login")
+	expireCookie(w, "Password")
+
 	http.SetCookie(w, &http.Cookie{
-		Name:    "Login",
-		Value:   jsonUser.Login,
-		Expires: time.Now().Add(time.Hour * 24 * 7),
-	})
-	http.SetCookie(w, &http.Cookie{
-		Name:    "Password",
-		Value:   models.GetMD5Hash(jsonUser.Password),
-		Expires: time.Now().Add(time.Hour * 24 * 7),
+		Name:     sessionCookie,
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.Expires,
+		HttpOnly: true,
+		Secure:   config.GetSecureCookies(),
+		SameSite: http.SameSiteLaxMode,
 	})
 
 	respondWithJson(w, r, &requestResult{Status: "OK"})
@@ -371,17 +689,64 @@ func (h *HttpHandlers) PostLogin(w http.ResponseWriter, r *http.Request, p httpr
 }
 
 func (h *HttpHandlers) GetLogout(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	http.SetCookie(w, &http.Cookie{
-		Name:    "Login",
-		MaxAge:  -1,
-		Expires: time.Now().Add(-time.Hour * 24),
-	})
-	http.SetCookie(w, &http.Cookie{
-		Name:    "Password",
-		MaxAge:  -1,
-		Expires: time.Now().Add(-time.Hour * 24),
-	})
+	if token, err := r.Cookie(sessionCookie); err == nil {
+		if err := models.DeleteSession(h.Env.DB, token.Value); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			log.Printf("Can't delete session: %v", err)
+			return
+		}
+	}
+
+	expireCookie(w, sessionCookie)
+	expireCookie(w, "Login")
+	expireCookie(w, "Password")
+	respondWithJson(w, r, &requestResult{Status: "OK"})
+}
+
+func (h *HttpHandlers) GetSessions(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	user := currentUser(r)
+	current := currentSession(r)
+
+	sessions, err := models.LoadUserSessions(h.Env.DB, user.Id)
+	if err != nil {
+		log.Print("Can't load sessions: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load sessions"}, http.StatusInternalServerError)
+		return
+	}
+
+	type jsonSession struct {
+		*models.Session
+		Current bool `json:"current"`
+	}
+
+	result := make([]*jsonSession, len(sessions))
+	for i, s := range sessions {
+		result[i] = &jsonSession{Session: s, Current: s.Id == current.Id}
+	}
+
+	if err := respondWithJson(w, r, result); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
+
+func (h *HttpHandlers) DeleteSession(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	user := currentUser(r)
+
+	paramId := p.ByName("id")
+	id, err := strconv.ParseInt(paramId, 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		log.Printf("Bad session id: %s", paramId)
+		return
+	}
+
+	if err := models.DeleteUserSession(h.Env.DB, user.Id, id); err != nil {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusNotFound)
+		return
+	}
+
 	respondWithJson(w, r, &requestResult{Status: "OK"})
+	log.Printf("Session %d of user %s revoked", id, user.Login)
 }
 
 func (h *HttpHandlers) GetLogin(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
@@ -400,14 +765,49 @@ func (h *HttpHandlers) GetUsers(w http.ResponseWriter, r *http.Request, _ httpro
 		return
 	}
 
-	if err := respondWithJson(w, r, users); err != nil {
+	awards, err := models.LoadAwards(h.Env.DB)
+	if err != nil {
+		log.Print("Can't load awards: ", err)
+		return
+	}
+
+	// users are cached, so achievements go to a copy
+	type userWithAchievements struct {
+		*models.User
+		Achievements []*models.Award `json:"achievements"`
+	}
+	result := make([]*userWithAchievements, 0, len(users))
+	byUser := make(map[int64]*userWithAchievements)
+	for _, u := range users {
+		elem := &userWithAchievements{User: u, Achievements: make([]*models.Award, 0)}
+		result = append(result, elem)
+		byUser[u.Id] = elem
+	}
+	for _, a := range awards {
+		if elem, ok := byUser[a.UserId]; ok {
+			elem.Achievements = append(elem.Achievements, a)
+		}
+	}
+
+	if err := respondWithJson(w, r, result); err != nil {
 		log.Print("Can't send response: ", err)
 		return
 	}
 }
 
 func (h *HttpHandlers) GetStages(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	stages, err := models.LoadStages(h.Env.DB)
+	var stages []*models.Stage
+	var err error
+	if len(r.URL.Query().Get("season")) == 0 {
+		stages, err = models.LoadStages(h.Env.DB)
+	} else {
+		season, seasonErr := requestedSeason(w, r, h.Env.DB)
+		if seasonErr != nil {
+			log.Print("Can't scope stages: ", seasonErr)
+			return
+		}
+		stages, err = models.LoadStagesBySeason(h.Env.DB, season.Id)
+	}
 	if err != nil {
 		log.Print("Can't load stages: ", err)
 		respondWithJson(w, r, &requestResult{Status: "Fail", Text: "Can't load stages"})
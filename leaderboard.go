@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aelnor/vangothrone/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+// loadSeasonMatches collects matches of every stage of the season. Matches
+// that fall into several stages are counted once.
+func loadSeasonMatches(db *sql.DB, seasonId int64) ([]*models.Match, error) {
+	stages, err := models.LoadStagesBySeason(db, seasonId)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int64]bool)
+	matches := make([]*models.Match, 0)
+	for _, s := range stages {
+		stageMatches, err := models.LoadMatchesByStage(db, s)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range stageMatches {
+			if seen[m.Id] {
+				continue
+			}
+			seen[m.Id] = true
+			matches = append(matches, m)
+		}
+	}
+
+	return matches, nil
+}
+
+func respondWithLeaderboard(w http.ResponseWriter, r *http.Request, db *sql.DB, matches []*models.Match, picks []*models.FuturePick) {
+	members, err := leagueMembers(w, r, db)
+	if err != nil {
+		log.Print("Can't scope leaderboard: ", err)
+		return
+	}
+
+	users, err := models.LoadUsers(db)
+	if err != nil {
+		log.Print("Can't load users: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load users"}, http.StatusInternalServerError)
+		return
+	}
+
+	if members != nil {
+		leagueUsers := make([]*models.User, 0, len(members))
+		for _, u := range users {
+			if members[u.Id] {
+				leagueUsers = append(leagueUsers, u)
+			}
+		}
+		users = leagueUsers
+	}
+
+	predictions, err := models.LoadPredictionsByMatches(db, matches)
+	if err != nil {
+		log.Print("Can't load predictions: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load predictions"}, http.StatusInternalServerError)
+		return
+	}
+
+	if err := respondWithJson(w, r, models.BuildLeaderboard(users, matches, predictions, picks)); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
+
+func (h *HttpHandlers) GetLeaderboard(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	season, err := requestedSeason(w, r, h.Env.DB)
+	if err != nil {
+		log.Print("Can't scope leaderboard: ", err)
+		return
+	}
+
+	matches, err := loadSeasonMatches(h.Env.DB, season.Id)
+	if err != nil {
+		log.Print("Can't load matches: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load matches"}, http.StatusInternalServerError)
+		return
+	}
+
+	picks, err := models.LoadPicksBySeason(h.Env.DB, season.Id)
+	if err != nil {
+		log.Print("Can't load picks: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load picks"}, http.StatusInternalServerError)
+		return
+	}
+
+	respondWithLeaderboard(w, r, h.Env.DB, matches, picks)
+}
+
+func (h *HttpHandlers) GetStageLeaderboard(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	paramId := p.ByName("id")
+	id, err := strconv.ParseInt(paramId, 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		log.Printf("Bad stage id: %s", paramId)
+		return
+	}
+
+	stage, err := models.LoadStage(h.Env.DB, id)
+	if err == sql.ErrNoRows {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Stage is not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Print("Can't load stage: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load stage"}, http.StatusInternalServerError)
+		return
+	}
+
+	matches, err := models.LoadMatchesByStage(h.Env.DB, stage)
+	if err != nil {
+		log.Print("Can't load matches: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load matches"}, http.StatusInternalServerError)
+		return
+	}
+
+	picks, err := models.LoadPicksByStage(h.Env.DB, stage.Id)
+	if err != nil {
+		log.Print("Can't load picks: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load picks"}, http.StatusInternalServerError)
+		return
+	}
+
+	respondWithLeaderboard(w, r, h.Env.DB, matches, picks)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aelnor/vangothrone/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+// leagueMembers resolves the "league" query parameter into the set of league
+// members. Nil set means that the request isn't scoped to a league. Only
+// members and admins may look inside a league. On failure the response is
+// already sent.
+func leagueMembers(w http.ResponseWriter, r *http.Request, db *sql.DB) (map[int64]bool, error) {
+	param := r.URL.Query().Get("league")
+	if len(param) == 0 {
+		return nil, nil
+	}
+
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Bad league id"}, http.StatusBadRequest)
+		return nil, fmt.Errorf("Bad league id: %s", param)
+	}
+
+	user := currentUser(r)
+	if user == nil {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Not logged in"}, http.StatusUnauthorized)
+		return nil, fmt.Errorf("Anonymous access to league %d", id)
+	}
+
+	members, err := models.LoadLeagueMembers(db, id)
+	if err != nil {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load league"}, http.StatusInternalServerError)
+		return nil, fmt.Errorf("Can't load league members: %v", err)
+	}
+
+	if !members[user.Id] && !user.IsAdmin {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Not a member of the league"}, http.StatusForbidden)
+		return nil, fmt.Errorf("User %s is not a member of league %d", user.Login, id)
+	}
+
+	return members, nil
+}
+
+func (h *HttpHandlers) GetLeagues(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	leagues, err := models.LoadUserLeagues(h.Env.DB, currentUser(r).Id)
+	if err != nil {
+		log.Print("Can't load leagues: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load leagues"}, http.StatusInternalServerError)
+		return
+	}
+
+	if err := respondWithJson(w, r, leagues); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
+
+func (h *HttpHandlers) PostLeagues(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var jsonLeague struct {
+		Name           string `json:"name"`
+		HidePredictors bool   `json:"hidePredictors"`
+	}
+
+	if err := processBody(w, r, &jsonLeague); err != nil {
+		log.Printf("Can't process league adding: %v", err)
+		return
+	}
+
+	l := &models.League{
+		Name:           strings.TrimSpace(jsonLeague.Name),
+		OwnerId:        currentUser(r).Id,
+		HidePredictors: jsonLeague.HidePredictors,
+	}
+	if len(l.Name) == 0 {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "League name is empty"}, http.StatusBadRequest)
+		return
+	}
+
+	if err := models.CreateLeague(h.Env.DB, l); err != nil {
+		log.Printf("Can't create league: %v", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't create league"}, http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJsonAndStatus(w, r, l, http.StatusCreated)
+	log.Printf("League added: %+v", l)
+}
+
+// PutLeague changes the name and the options of a league. Only the owner and admins may do it.
+func (h *HttpHandlers) PutLeague(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	paramId := p.ByName("id")
+	id, err := strconv.ParseInt(paramId, 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		log.Printf("Bad league id: %s", paramId)
+		return
+	}
+
+	l, err := models.LoadLeague(h.Env.DB, id)
+	if err == sql.ErrNoRows {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "League is not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Print("Can't load league: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load league"}, http.StatusInternalServerError)
+		return
+	}
+
+	user := currentUser(r)
+	if l.OwnerId != user.Id && !user.IsAdmin {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Only the owner can change the league"}, http.StatusForbidden)
+		return
+	}
+
+	var jsonLeague struct {
+		Name           string `json:"name"`
+		HidePredictors *bool  `json:"hidePredictors"`
+	}
+
+	if err := processBody(w, r, &jsonLeague); err != nil {
+		log.Printf("Can't process league editing: %v", err)
+		return
+	}
+
+	if len(jsonLeague.Name) != 0 {
+		l.Name = jsonLeague.Name
+	}
+	if jsonLeague.HidePredictors != nil {
+		l.HidePredictors = *jsonLeague.HidePredictors
+	}
+	if err := models.SaveLeague(h.Env.DB, l); err != nil {
+		log.Printf("Can't save league: %v", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	respondWithJson(w, r, l)
+	log.Printf("League saved: %+v", l)
+}
+
+func (h *HttpHandlers) PostLeaguesJoin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var jsonJoin struct {
+		Code string `json:"code"`
+	}
+
+	if err := processBody(w, r, &jsonJoin); err != nil {
+		log.Printf("Can't process league joining: %v", err)
+		return
+	}
+
+	user := currentUser(r)
+	l, err := models.JoinLeague(h.Env.DB, jsonJoin.Code, user.Id)
+	if err != nil {
+		log.Printf("Can't join league: %v", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	respondWithJson(w, r, l)
+	log.Printf("User %s joined league %d", user.Login, l.Id)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aelnor/vangothrone/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	defaultMatchesLimit = 50
+	maxMatchesLimit     = 200
+)
+
+var matchFilterParams = []string{"stage", "from", "to", "team", "status", "limit", "offset"}
+
+// hasMatchFilter tells whether the request asks for anything but the current stage.
+func hasMatchFilter(r *http.Request) bool {
+	query := r.URL.Query()
+	for _, param := range matchFilterParams {
+		if len(query.Get(param)) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMatchFilter builds a match filter out of the query parameters. A stage
+// narrows the dates down to the stage and implies its season. On failure the
+// response is already sent.
+func parseMatchFilter(w http.ResponseWriter, r *http.Request, db *sql.DB) (*models.MatchFilter, error) {
+	query := r.URL.Query()
+	filter := &models.MatchFilter{
+		Team:   strings.ToUpper(query.Get("team")),
+		Status: query.Get("status"),
+		Limit:  defaultMatchesLimit,
+	}
+
+	fail := func(text string) (*models.MatchFilter, error) {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: text}, http.StatusBadRequest)
+		return nil, fmt.Errorf("%s: %s", text, r.URL.RawQuery)
+	}
+
+	switch filter.Status {
+	case "", models.MATCH_UPCOMING, models.STATUS_LIVE, models.STATUS_FINISHED, models.STATUS_POSTPONED, models.STATUS_CANCELLED:
+	default:
+		return fail("Status should be one of upcoming, live, finished, postponed, cancelled")
+	}
+
+	for _, param := range []string{"from", "to"} {
+		value := query.Get(param)
+		if len(value) == 0 {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fail(fmt.Sprintf("Bad %s date, RFC 3339 is expected", param))
+		}
+		if param == "from" {
+			filter.From = date
+		} else {
+			filter.To = date
+		}
+	}
+
+	var err error
+	if value := query.Get("limit"); len(value) != 0 {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 || filter.Limit > maxMatchesLimit {
+			return fail(fmt.Sprintf("Limit should be between 1 and %d", maxMatchesLimit))
+		}
+	}
+	if value := query.Get("offset"); len(value) != 0 {
+		if filter.Offset, err = strconv.Atoi(value); err != nil || filter.Offset < 0 {
+			return fail("Offset should be a non-negative number")
+		}
+	}
+
+	if value := query.Get("stage"); len(value) != 0 {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fail("Bad stage id")
+		}
+		stage, err := models.LoadStage(db, id)
+		if err == sql.ErrNoRows {
+			return fail("Stage is not found")
+		}
+		if err != nil {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load stage"}, http.StatusInternalServerError)
+			return nil, err
+		}
+
+		filter.SeasonId = stage.SeasonId
+		if filter.From.IsZero() || filter.From.Before(stage.StartDate) {
+			filter.From = stage.StartDate
+		}
+		if filter.To.IsZero() || filter.To.After(stage.EndDate) {
+			filter.To = stage.EndDate
+		}
+	} else if len(query.Get("season")) != 0 {
+		season, err := requestedSeason(w, r, db)
+		if err != nil {
+			return nil, err
+		}
+		filter.SeasonId = season.Id
+	}
+
+	return filter, nil
+}
+
+func (h *HttpHandlers) GetMatch(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	paramId := p.ByName("id")
+	id, err := strconv.ParseInt(paramId, 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		log.Printf("Bad match id: %s", paramId)
+		return
+	}
+
+	members, err := leagueMembers(w, r, h.Env.DB)
+	if err != nil {
+		log.Print("Can't scope match: ", err)
+		return
+	}
+
+	match, err := models.LoadMatch(h.Env.DB, id)
+	if err == sql.ErrNoRows {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Match is not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Print("Can't load match: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load match"}, http.StatusInternalServerError)
+		return
+	}
+
+	predictions, err := models.LoadPredictionsByMatch(h.Env.DB, id)
+	if err != nil {
+		log.Print("Can't load predictions: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load predictions"}, http.StatusInternalServerError)
+		return
+	}
+
+	v, err := newVisibility(h.Env.DB, currentUser(r))
+	if err != nil {
+		log.Print("Can't load leagues: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load predictions"}, http.StatusInternalServerError)
+		return
+	}
+	attachPredictions([]*models.Match{match}, predictions, v, members)
+	attachStats([]*models.Match{match}, predictions, v)
+
+	if err := respondWithJson(w, r, match); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
+
+// GetMatchStats shows how the crowd predicts the match. Stats don't reveal
+// single predictions, so they are available before predictions lock.
+func (h *HttpHandlers) GetMatchStats(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	paramId := p.ByName("id")
+	id, err := strconv.ParseInt(paramId, 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		log.Printf("Bad match id: %s", paramId)
+		return
+	}
+
+	match, err := models.LoadMatch(h.Env.DB, id)
+	if err == sql.ErrNoRows {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Match is not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Print("Can't load match: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load match"}, http.StatusInternalServerError)
+		return
+	}
+
+	stats, err := cached.MatchStats(h.Env.DB, match)
+	if err != nil {
+		log.Print("Can't compute match stats: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load predictions"}, http.StatusInternalServerError)
+		return
+	}
+
+	if err := respondWithJson(w, r, stats); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
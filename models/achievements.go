@@ -0,0 +1,330 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Achievement is a badge users earn for their predictions. Achievements are
+// earned once per season and checked against finished matches of the season
+// only, so they never reveal anything. A new achievement only needs to be
+// added to the Achievements list.
+type Achievement struct {
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	earned func(c *achievementContext, userId int64) bool
+}
+
+// Award is an achievement earned by a user in a season.
+type Award struct {
+	UserId   int64     `json:"userId"`
+	Code     string    `json:"code"`
+	SeasonId int64     `json:"seasonId"`
+	Awarded  time.Time `json:"awarded"`
+}
+
+const (
+	UNDERDOG_SHARE           = 25
+	UNDERDOG_MIN_PREDICTIONS = 4
+	STREAK_LENGTH            = 10
+)
+
+var Achievements = []*Achievement{
+	{
+		Code:        "perfect_week",
+		Name:        "Perfect week",
+		Description: "Predict the winner of every match of a week",
+		earned:      earnedPerfectWeek,
+	},
+	{
+		Code:        "underdog_call",
+		Name:        "Underdog call",
+		Description: fmt.Sprintf("Predict the winner backed by less than %d%% of players", UNDERDOG_SHARE),
+		earned:      earnedUnderdogCall,
+	},
+	{
+		Code:        "streak_10",
+		Name:        "On fire",
+		Description: fmt.Sprintf("Predict the winners of %d matches in a row", STREAK_LENGTH),
+		earned:      earnedStreak,
+	},
+	{
+		Code:        "full_stage",
+		Name:        "Never missed",
+		Description: "Predict every match of a stage",
+		earned:      earnedFullStage,
+	},
+}
+
+const (
+	CREATE_USER_ACHIEVEMENTS_TABLE = "CREATE TABLE IF NOT EXISTS UserAchievements(user_id, code, season_id, awarded, UNIQUE(user_id, code, season_id))"
+	INSERT_AWARD                   = "INSERT OR IGNORE INTO UserAchievements(user_id, code, season_id, awarded) VALUES(?,?,?,?)"
+	DELETE_AWARD                   = "DELETE FROM UserAchievements WHERE user_id=? AND code=? AND season_id=?"
+	SELECT_ALL_AWARDS              = "SELECT user_id, code, season_id, awarded FROM UserAchievements"
+	SELECT_AWARDS                  = SELECT_ALL_AWARDS + " ORDER BY awarded, rowid"
+	SELECT_SEASON_AWARDS           = SELECT_ALL_AWARDS + " WHERE season_id=? ORDER BY awarded, rowid"
+)
+
+func InitUserAchievementsTable(db *sql.DB) error {
+	_, err := db.Exec(CREATE_USER_ACHIEVEMENTS_TABLE)
+	return err
+}
+
+// achievementContext holds matches of a season and predictions for them.
+type achievementContext struct {
+	matches     []*Match
+	stages      []*Stage
+	predictions map[int64]map[int64]*Prediction
+	stats       map[int64]*MatchStats
+}
+
+func loadAchievementContext(db *sql.DB, seasonId int64) (*achievementContext, error) {
+	matches, err := LoadMatchesBySeason(db, seasonId)
+	if err != nil {
+		return nil, err
+	}
+	stages, err := LoadStagesBySeason(db, seasonId)
+	if err != nil {
+		return nil, err
+	}
+	predictions, err := LoadPredictionsByMatches(db, matches)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAchievementContext(matches, stages, predictions), nil
+}
+
+func newAchievementContext(matches []*Match, stages []*Stage, predictions []*Prediction) *achievementContext {
+	c := &achievementContext{
+		matches:     make([]*Match, 0, len(matches)),
+		stages:      stages,
+		predictions: make(map[int64]map[int64]*Prediction),
+		stats:       make(map[int64]*MatchStats),
+	}
+	for _, m := range matches {
+		if m.Status != STATUS_CANCELLED {
+			c.matches = append(c.matches, m)
+		}
+	}
+
+	byMatch := make(map[int64][]*Prediction)
+	for _, pred := range predictions {
+		if c.predictions[pred.UserId] == nil {
+			c.predictions[pred.UserId] = make(map[int64]*Prediction)
+		}
+		c.predictions[pred.UserId][pred.MatchId] = pred
+		byMatch[pred.MatchId] = append(byMatch[pred.MatchId], pred)
+	}
+	for _, m := range c.matches {
+		c.stats[m.Id] = ComputeMatchStats(m.Id, byMatch[m.Id])
+	}
+
+	return c
+}
+
+// outcome evaluates the prediction of the user for the finished match, nil if there is none.
+func (c *achievementContext) outcome(userId int64, m *Match) *Outcome {
+	pred, ok := c.predictions[userId][m.Id]
+	if !ok || !m.IsScored() {
+		return nil
+	}
+	o, err := Evaluate(m.Result, pred.Score)
+	if err != nil {
+		return nil
+	}
+	return &o
+}
+
+func earnedPerfectWeek(c *achievementContext, userId int64) bool {
+	type week struct{ year, number int }
+	complete := make(map[week]bool)
+	perfect := make(map[week]bool)
+	for _, m := range c.matches {
+		year, number := m.Date.ISOWeek()
+		w := week{year, number}
+		if _, ok := complete[w]; !ok {
+			complete[w], perfect[w] = true, true
+		}
+		if !m.IsScored() {
+			complete[w] = false
+			continue
+		}
+		if o := c.outcome(userId, m); o == nil || !o.Winner {
+			perfect[w] = false
+		}
+	}
+
+	for w := range complete {
+		if complete[w] && perfect[w] {
+			return true
+		}
+	}
+	return false
+}
+
+func earnedUnderdogCall(c *achievementContext, userId int64) bool {
+	for _, m := range c.matches {
+		o := c.outcome(userId, m)
+		if o == nil || !o.Winner {
+			continue
+		}
+		result, err := ParseScore(m.Result)
+		if err != nil || result.IsDraw() {
+			continue
+		}
+		stats := c.stats[m.Id]
+		if stats.Predictions >= UNDERDOG_MIN_PREDICTIONS && stats.Teams[result.Winner()] < UNDERDOG_SHARE {
+			return true
+		}
+	}
+	return false
+}
+
+// earnedStreak counts predictions in a row like LoadUserStats does: matches
+// the user hasn't predicted don't break a streak.
+func earnedStreak(c *achievementContext, userId int64) bool {
+	streak := 0
+	for _, m := range c.matches {
+		if _, ok := c.predictions[userId][m.Id]; !ok || !m.IsScored() {
+			continue
+		}
+		if o := c.outcome(userId, m); o == nil || !o.Winner {
+			streak = 0
+			continue
+		}
+		streak++
+		if streak >= STREAK_LENGTH {
+			return true
+		}
+	}
+	return false
+}
+
+func earnedFullStage(c *achievementContext, userId int64) bool {
+	for _, s := range c.stages {
+		played, full := 0, true
+		for _, m := range c.matches {
+			if stageOf(c.stages, m) != s {
+				continue
+			}
+			played++
+			if _, ok := c.predictions[userId][m.Id]; !ok || !m.IsScored() {
+				full = false
+				break
+			}
+		}
+		if played != 0 && full {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAwards compares achievements the users have earned in the season with
+// the awards they have. It returns the awards to give and to take back.
+func checkAwards(c *achievementContext, seasonId int64, users []*User, awards []*Award, now time.Time) ([]*Award, []*Award) {
+	has := make(map[int64]map[string]*Award)
+	for _, a := range awards {
+		if a.SeasonId != seasonId {
+			continue
+		}
+		if has[a.UserId] == nil {
+			has[a.UserId] = make(map[string]*Award)
+		}
+		has[a.UserId][a.Code] = a
+	}
+
+	added := make([]*Award, 0)
+	revoked := make([]*Award, 0)
+	for _, u := range users {
+		for _, a := range Achievements {
+			award, ok := has[u.Id][a.Code]
+			switch earned := a.earned(c, u.Id); {
+			case earned && !ok:
+				added = append(added, &Award{UserId: u.Id, Code: a.Code, SeasonId: seasonId, Awarded: now})
+			case !earned && ok:
+				revoked = append(revoked, award)
+			}
+		}
+	}
+	return added, revoked
+}
+
+// EvaluateAchievements checks achievements of all users in the season again.
+// Users get achievements they have earned and lose ones they no longer
+// deserve, like after a result is corrected or a match is cancelled or
+// deleted. It returns the awards given and taken back.
+func EvaluateAchievements(db *sql.DB, seasonId int64) ([]*Award, []*Award, error) {
+	users, err := LoadUsers(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	awards, err := LoadSeasonAwards(db, seasonId)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := loadAchievementContext(db, seasonId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Can't load predictions: %s", err.Error())
+	}
+
+	added, revoked := checkAwards(c, seasonId, users, awards, time.Now().UTC())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, a := range added {
+		if _, err := tx.Exec(INSERT_AWARD, a.UserId, a.Code, a.SeasonId, a.Awarded.Format(TIMEFORMAT)); err != nil {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("Can't save award: %s", err.Error())
+		}
+	}
+	for _, a := range revoked {
+		if _, err := tx.Exec(DELETE_AWARD, a.UserId, a.Code, a.SeasonId); err != nil {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("Can't delete award: %s", err.Error())
+		}
+	}
+
+	return added, revoked, tx.Commit()
+}
+
+func loadAwards(db *sql.DB, query string, args ...interface{}) ([]*Award, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	awards := make([]*Award, 0)
+	for rows.Next() {
+		a := new(Award)
+		var awarded string
+		if err := rows.Scan(&a.UserId, &a.Code, &a.SeasonId, &awarded); err != nil {
+			return nil, fmt.Errorf("Can't parse awards: %s", err.Error())
+		}
+		if a.Awarded, err = time.Parse(TIMEFORMAT, awarded); err != nil {
+			return nil, fmt.Errorf("Can't parse date %s: %s", awarded, err.Error())
+		}
+		awards = append(awards, a)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return awards, nil
+}
+
+func LoadAwards(db *sql.DB) ([]*Award, error) {
+	return loadAwards(db, SELECT_AWARDS)
+}
+
+func LoadSeasonAwards(db *sql.DB, seasonId int64) ([]*Award, error) {
+	return loadAwards(db, SELECT_SEASON_AWARDS, seasonId)
+}
@@ -0,0 +1,190 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// monday is the start of a week long gone, so matches of the tests are locked.
+var monday = time.Date(2024, 3, 4, 18, 0, 0, 0, time.UTC)
+
+func seasonMatch(id int64, day int, result string) *Match {
+	return &Match{Id: id, SeasonId: 1, Teams: [2]string{"AAA", "BBB"}, Date: monday.AddDate(0, 0, day), Result: result}
+}
+
+func pick(userId int64, matchId int64, score string) *Prediction {
+	return &Prediction{UserId: userId, MatchId: matchId, Score: score}
+}
+
+func TestEarnedPerfectWeek(t *testing.T) {
+	cancelled := seasonMatch(3, 4, "")
+	cancelled.Status = STATUS_CANCELLED
+
+	tests := []struct {
+		name    string
+		matches []*Match
+		preds   []*Prediction
+		want    bool
+	}{
+		{
+			name:    "every winner of the week",
+			matches: []*Match{seasonMatch(1, 0, "3:1"), seasonMatch(2, 2, "0:3")},
+			preds:   []*Prediction{pick(1, 1, "3:0"), pick(1, 2, "1:3")},
+			want:    true,
+		},
+		{
+			name:    "a winner missed",
+			matches: []*Match{seasonMatch(1, 0, "3:1"), seasonMatch(2, 2, "0:3")},
+			preds:   []*Prediction{pick(1, 1, "3:0"), pick(1, 2, "3:1")},
+		},
+		{
+			name:    "a match not predicted",
+			matches: []*Match{seasonMatch(1, 0, "3:1"), seasonMatch(2, 2, "0:3")},
+			preds:   []*Prediction{pick(1, 1, "3:0")},
+		},
+		{
+			name:    "the week isn't over",
+			matches: []*Match{seasonMatch(1, 0, "3:1"), seasonMatch(2, 2, "")},
+			preds:   []*Prediction{pick(1, 1, "3:0"), pick(1, 2, "3:0")},
+		},
+		{
+			name:    "cancelled matches don't count",
+			matches: []*Match{seasonMatch(1, 0, "3:1"), seasonMatch(2, 2, "0:3"), cancelled},
+			preds:   []*Prediction{pick(1, 1, "3:0"), pick(1, 2, "1:3")},
+			want:    true,
+		},
+		{
+			name:    "one perfect week is enough",
+			matches: []*Match{seasonMatch(1, 0, "3:1"), seasonMatch(2, 7, "0:3")},
+			preds:   []*Prediction{pick(1, 1, "0:3"), pick(1, 2, "1:3")},
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		c := newAchievementContext(tt.matches, nil, tt.preds)
+		if got := earnedPerfectWeek(c, 1); got != tt.want {
+			t.Errorf("%s: earnedPerfectWeek = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEarnedUnderdogCall(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+		scores []string
+		want   bool
+	}{
+		{"backed by few", "0:3", []string{"3:0", "3:1", "3:2", "3:0", "0:3"}, true},
+		{"backed by a quarter", "0:3", []string{"3:0", "3:1", "3:2", "0:3"}, false},
+		{"too few predictions", "0:3", []string{"3:0", "3:1", "0:3"}, false},
+		{"the favourite won", "0:3", []string{"0:3", "0:3", "3:0", "0:3", "0:3"}, false},
+		{"no result yet", "", []string{"3:0", "3:1", "3:2", "3:0", "0:3"}, false},
+	}
+
+	for _, tt := range tests {
+		preds := make([]*Prediction, len(tt.scores))
+		for i, score := range tt.scores {
+			preds[i] = pick(int64(i+1), 1, score)
+		}
+		c := newAchievementContext([]*Match{seasonMatch(1, 0, tt.result)}, nil, preds)
+		if got := earnedUnderdogCall(c, int64(len(preds))); got != tt.want {
+			t.Errorf("%s: earnedUnderdogCall = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEarnedStreak(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores []string
+		want   bool
+	}{
+		{"a full streak", []string{"3:0", "3:0", "3:0", "3:0", "3:0", "3:0", "3:0", "3:0", "3:0", "3:0"}, true},
+		{"one match short", []string{"3:0", "3:0", "3:0", "3:0", "3:0", "3:0", "3:0", "3:0", "3:0"}, false},
+		{"a miss in between", []string{"3:0", "3:0", "3:0", "3:0", "0:3", "3:0", "3:0", "3:0", "3:0", "3:0", "3:0"}, false},
+		{"an unread prediction in between", []string{"3:0", "3:0", "3:0", "3:0", "bad", "3:0", "3:0", "3:0", "3:0", "3:0", "3:0"}, false},
+		{"a skipped match in between", []string{"3:0", "3:0", "3:0", "3:0", "", "3:0", "3:0", "3:0", "3:0", "3:0", "3:0"}, true},
+	}
+
+	for _, tt := range tests {
+		matches := make([]*Match, 0, len(tt.scores))
+		preds := make([]*Prediction, 0, len(tt.scores))
+		for i, score := range tt.scores {
+			m := seasonMatch(int64(i+1), i, "3:1")
+			matches = append(matches, m)
+			if len(score) != 0 {
+				preds = append(preds, pick(1, m.Id, score))
+			}
+		}
+		c := newAchievementContext(matches, nil, preds)
+		if got := earnedStreak(c, 1); got != tt.want {
+			t.Errorf("%s: earnedStreak = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEarnedFullStage(t *testing.T) {
+	stages := []*Stage{{Id: 1, SeasonId: 1, StartDate: monday, EndDate: monday.AddDate(0, 0, 6)}}
+
+	tests := []struct {
+		name    string
+		matches []*Match
+		preds   []*Prediction
+		want    bool
+	}{
+		{
+			name:    "every match predicted",
+			matches: []*Match{seasonMatch(1, 0, "3:1"), seasonMatch(2, 3, "0:3"), seasonMatch(3, 10, "3:0")},
+			preds:   []*Prediction{pick(1, 1, "0:3"), pick(1, 2, "3:0")},
+			want:    true,
+		},
+		{
+			name:    "a match missed",
+			matches: []*Match{seasonMatch(1, 0, "3:1"), seasonMatch(2, 3, "0:3")},
+			preds:   []*Prediction{pick(1, 1, "0:3")},
+		},
+		{
+			name:    "the stage isn't over",
+			matches: []*Match{seasonMatch(1, 0, "3:1"), seasonMatch(2, 3, "")},
+			preds:   []*Prediction{pick(1, 1, "0:3"), pick(1, 2, "3:0")},
+		},
+		{
+			name:    "no matches in the stage",
+			matches: []*Match{seasonMatch(1, 10, "3:1")},
+			preds:   []*Prediction{pick(1, 1, "0:3")},
+		},
+	}
+
+	for _, tt := range tests {
+		c := newAchievementContext(tt.matches, stages, tt.preds)
+		if got := earnedFullStage(c, 1); got != tt.want {
+			t.Errorf("%s: earnedFullStage = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckAwards(t *testing.T) {
+	now := time.Now().UTC()
+	users := []*User{{Id: 1}, {Id: 2}}
+
+	// The result of the only match was corrected, so user 1 no longer has a
+	// perfect week and user 2 now does.
+	matches := []*Match{seasonMatch(1, 0, "0:3")}
+	preds := []*Prediction{pick(1, 1, "3:0"), pick(2, 1, "0:3")}
+	awards := []*Award{
+		{UserId: 1, Code: "perfect_week", SeasonId: 1},
+		{UserId: 1, Code: "full_stage", SeasonId: 2},
+		{UserId: 2, Code: "full_stage", SeasonId: 2},
+	}
+
+	added, revoked := checkAwards(newAchievementContext(matches, nil, preds), 1, users, awards, now)
+
+	if len(added) != 1 || *added[0] != (Award{UserId: 2, Code: "perfect_week", SeasonId: 1, Awarded: now}) {
+		t.Errorf("added awards = %v, want perfect_week of user 2", added)
+	}
+	if len(revoked) != 1 || revoked[0] != awards[0] {
+		t.Errorf("revoked awards = %v, want perfect_week of user 1", revoked)
+	}
+}
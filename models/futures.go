@@ -0,0 +1,235 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Market is an outright question like "who wins the stage playoffs" or "who
+// wins the season". A market without StageId is about the whole season.
+// Users pick a team until the deadline, and the market is settled by setting
+// its winner.
+type Market struct {
+	Id       int64         `json:"id"`
+	Name     string        `json:"name"`
+	SeasonId int64         `json:"seasonId"`
+	StageId  int64         `json:"stageId"`
+	Deadline time.Time     `json:"deadline"`
+	Points   int           `json:"points"`
+	Winner   string        `json:"winner"`
+	Picks    []*FuturePick `json:"picks"`
+}
+
+type FuturePick struct {
+	UserId   int64  `json:"userId"`
+	MarketId int64  `json:"-"`
+	Team     string `json:"team"`
+	Points   int    `json:"points"`
+	Hidden   bool   `json:"hidden"`
+}
+
+const DEFAULT_MARKET_POINTS = 10
+
+const (
+	CREATE_MARKETS_TABLE      = "CREATE TABLE IF NOT EXISTS FutureMarkets(name, season_id, stage_id, deadline, points, winner DEFAULT '')"
+	CREATE_FUTURE_PICKS_TABLE = "CREATE TABLE IF NOT EXISTS FuturePicks(user_id, market_id, team, points DEFAULT 0, UNIQUE(user_id, market_id))"
+	SELECT_ALL_MARKETS        = "SELECT rowid, name, season_id, stage_id, deadline, points, winner FROM FutureMarkets"
+	SELECT_MARKET_BY_ID       = SELECT_ALL_MARKETS + " WHERE rowid=?"
+	SELECT_SEASON_MARKETS     = SELECT_ALL_MARKETS + " WHERE season_id=? ORDER BY deadline, rowid"
+	INSERT_MARKET             = "INSERT INTO FutureMarkets(name, season_id, stage_id, deadline, points) VALUES(?,?,?,?,?)"
+	SETTLE_MARKET             = "UPDATE FutureMarkets SET winner=? WHERE rowid=?"
+	SCORE_MARKET_PICKS        = "UPDATE FuturePicks SET points=CASE WHEN team=? THEN ? ELSE 0 END WHERE market_id=?"
+	SELECT_ALL_PICKS          = "SELECT user_id, market_id, team, points FROM FuturePicks"
+	SELECT_SEASON_PICKS       = SELECT_ALL_PICKS + " WHERE market_id IN (SELECT rowid FROM FutureMarkets WHERE season_id=?)"
+	SELECT_STAGE_PICKS        = SELECT_ALL_PICKS + " WHERE market_id IN (SELECT rowid FROM FutureMarkets WHERE stage_id=?)"
+	SAVE_PICK                 = "INSERT OR REPLACE INTO FuturePicks(user_id, market_id, team, points) VALUES(?,?,?,0)"
+)
+
+var ErrMarketClosed = errors.New("Market is closed")
+
+func InitFuturesTables(db *sql.DB) error {
+	if _, err := db.Exec(CREATE_MARKETS_TABLE); err != nil {
+		return err
+	}
+	_, err := db.Exec(CREATE_FUTURE_PICKS_TABLE)
+	return err
+}
+
+func scanMarket(row scannable) (*Market, error) {
+	m := new(Market)
+	var deadline string
+	if err := row.Scan(&m.Id, &m.Name, &m.SeasonId, &m.StageId, &deadline, &m.Points, &m.Winner); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if m.Deadline, err = time.Parse(TIMEFORMAT, deadline); err != nil {
+		return nil, fmt.Errorf("Can't parse date %s: %s", deadline, err.Error())
+	}
+	return m, nil
+}
+
+func (m *Market) IsPastDeadline() bool {
+	return !time.Now().UTC().Before(m.Deadline)
+}
+
+// IsOpen tells whether picks can still be made.
+func (m *Market) IsOpen() bool {
+	return len(m.Winner) == 0 && !m.IsPastDeadline()
+}
+
+// AddMarket stores a new market. Stage markets belong to the season of the stage.
+func AddMarket(db *sql.DB, m *Market) error {
+	m.Name = strings.TrimSpace(m.Name)
+	if len(m.Name) == 0 {
+		return fmt.Errorf("Market name is empty")
+	}
+	if m.Deadline.IsZero() {
+		return fmt.Errorf("Market deadline is not set")
+	}
+	if m.Points == 0 {
+		m.Points = DEFAULT_MARKET_POINTS
+	}
+	if m.Points < 0 {
+		return fmt.Errorf("Points can't be negative")
+	}
+
+	if m.StageId != 0 {
+		stage, err := LoadStage(db, m.StageId)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("No stage with id: %d", m.StageId)
+		}
+		if err != nil {
+			return err
+		}
+		m.SeasonId = stage.SeasonId
+	}
+	if err := checkSeasonTeams(db, m.SeasonId); err != nil {
+		return err
+	}
+
+	m.Winner = ""
+	result, err := db.Exec(INSERT_MARKET, m.Name, m.SeasonId, m.StageId, m.Deadline.UTC().Format(TIMEFORMAT), m.Points)
+	if err != nil {
+		return err
+	}
+
+	m.Id, _ = result.LastInsertId()
+	return nil
+}
+
+func LoadMarket(db *sql.DB, id int64) (*Market, error) {
+	return scanMarket(db.QueryRow(SELECT_MARKET_BY_ID, id))
+}
+
+func LoadMarketsBySeason(db *sql.DB, seasonId int64) ([]*Market, error) {
+	rows, err := db.Query(SELECT_SEASON_MARKETS, seasonId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	markets := make([]*Market, 0)
+	for rows.Next() {
+		m, err := scanMarket(rows)
+		if err != nil {
+			return nil, err
+		}
+		markets = append(markets, m)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return markets, nil
+}
+
+// SavePick stores the team the user picks in the market, replacing the previous pick.
+func SavePick(db *sql.DB, pick *FuturePick) error {
+	if pick.UserId == 0 {
+		return fmt.Errorf("User ID is null")
+	}
+
+	m, err := LoadMarket(db, pick.MarketId)
+	if err != nil {
+		return err
+	}
+	if !m.IsOpen() {
+		return ErrMarketClosed
+	}
+	pick.Team = strings.ToUpper(strings.TrimSpace(pick.Team))
+	if err := checkSeasonTeams(db, m.SeasonId, pick.Team); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(SAVE_PICK, pick.UserId, pick.MarketId, pick.Team)
+	return err
+}
+
+// SettleMarket sets the winner of the market and gives its points to the
+// users who picked it. Settling again corrects the winner.
+func SettleMarket(db *sql.DB, id int64, winner string) error {
+	m, err := LoadMarket(db, id)
+	if err != nil {
+		return err
+	}
+	if !m.IsPastDeadline() {
+		return fmt.Errorf("Market can't be settled before the deadline")
+	}
+	winner = strings.ToUpper(strings.TrimSpace(winner))
+	if err := checkSeasonTeams(db, m.SeasonId, winner); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(SETTLE_MARKET, winner, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(SCORE_MARKET_PICKS, winner, m.Points, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func loadPicks(db *sql.DB, query string, args ...interface{}) ([]*FuturePick, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Can't load picks: %s", err.Error())
+	}
+
+	defer rows.Close()
+
+	picks := make([]*FuturePick, 0)
+	for rows.Next() {
+		pick := new(FuturePick)
+		if err := rows.Scan(&pick.UserId, &pick.MarketId, &pick.Team, &pick.Points); err != nil {
+			return nil, fmt.Errorf("Can't parse picks: %s", err.Error())
+		}
+		picks = append(picks, pick)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return picks, nil
+}
+
+// LoadPicksBySeason returns picks made in all markets of the season, stage markets included.
+func LoadPicksBySeason(db *sql.DB, seasonId int64) ([]*FuturePick, error) {
+	return loadPicks(db, SELECT_SEASON_PICKS, seasonId)
+}
+
+func LoadPicksByStage(db *sql.DB, stageId int64) ([]*FuturePick, error) {
+	return loadPicks(db, SELECT_STAGE_PICKS, stageId)
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// HistoryEntry is a change of a prediction. OldScore is empty when the prediction was created.
+type HistoryEntry struct {
+	UserId   int64     `json:"userId"`
+	MatchId  int64     `json:"matchId"`
+	Changed  time.Time `json:"changed"`
+	OldScore string    `json:"oldScore"`
+	NewScore string    `json:"newScore"`
+	Ip       string    `json:"ip,omitempty"`
+}
+
+const (
+	CREATE_PREDICTION_HISTORY_TABLE = "CREATE TABLE IF NOT EXISTS PredictionHistory(user_id, match_id, changed, old_score, new_score, ip)"
+	INSERT_HISTORY_ENTRY            = "INSERT INTO PredictionHistory(user_id, match_id, changed, old_score, new_score, ip) VALUES(?,?,?,?,?,?)"
+	SELECT_MATCH_HISTORY            = "SELECT user_id, match_id, changed, old_score, new_score, ip FROM PredictionHistory WHERE match_id=? ORDER BY changed, rowid"
+	DELETE_MATCH_HISTORY            = "DELETE FROM PredictionHistory WHERE match_id=?"
+)
+
+func InitPredictionHistoryTable(db *sql.DB) error {
+	_, err := db.Exec(CREATE_PREDICTION_HISTORY_TABLE)
+	return err
+}
+
+func addHistoryEntry(q queryer, pred *Prediction, oldScore string) error {
+	changed := time.Now().UTC().Format(TIMEFORMAT)
+	if _, err := q.Exec(INSERT_HISTORY_ENTRY, pred.UserId, pred.MatchId, changed, oldScore, pred.Score, pred.Ip); err != nil {
+		return fmt.Errorf("Can't record prediction history: %s", err.Error())
+	}
+	return nil
+}
+
+// LoadPredictionHistory returns all changes of predictions for the match in the order they were made.
+func LoadPredictionHistory(db *sql.DB, matchId int64) ([]*HistoryEntry, error) {
+	rows, err := db.Query(SELECT_MATCH_HISTORY, matchId)
+	if err != nil {
+		return nil, fmt.Errorf("Can't load prediction history: %s", err.Error())
+	}
+
+	defer rows.Close()
+
+	entries := make([]*HistoryEntry, 0)
+	for rows.Next() {
+		e := new(HistoryEntry)
+		var changed string
+		if err := rows.Scan(&e.UserId, &e.MatchId, &changed, &e.OldScore, &e.NewScore, &e.Ip); err != nil {
+			return nil, fmt.Errorf("Can't parse prediction history: %s", err.Error())
+		}
+		if e.Changed, err = time.Parse(TIMEFORMAT, changed); err != nil {
+			return nil, fmt.Errorf("Can't parse date %s: %s", changed, err.Error())
+		}
+		entries = append(entries, e)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return entries, nil
+}
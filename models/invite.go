@@ -0,0 +1,97 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Invite lets one person register while registration is invite-only.
+type Invite struct {
+	Code      string    `json:"code"`
+	CreatedBy int64     `json:"createdBy"`
+	Created   time.Time `json:"created"`
+	UsedBy    int64     `json:"usedBy,omitempty"`
+}
+
+const (
+	CREATE_INVITES_TABLE = "CREATE TABLE IF NOT EXISTS Invites(code, created_by, created, used_by DEFAULT 0)"
+	INSERT_INVITE        = "INSERT INTO Invites(code, created_by, created, used_by) VALUES(?,?,?,0)"
+	SELECT_ALL_INVITES   = "SELECT code, created_by, created, used_by FROM Invites ORDER BY created DESC"
+	CLAIM_INVITE         = "UPDATE Invites SET used_by=-1 WHERE code=? AND used_by=0"
+	SET_INVITE_USER      = "UPDATE Invites SET used_by=? WHERE code=?"
+)
+
+func InitInvitesTable(db *sql.DB) error {
+	_, err := db.Exec(CREATE_INVITES_TABLE)
+	return err
+}
+
+// CreateInvite generates a code for a new invite.
+func CreateInvite(db *sql.DB, inv *Invite) error {
+	code, err := generateToken()
+	if err != nil {
+		return fmt.Errorf("Can't generate invite code: %s", err.Error())
+	}
+
+	inv.Code = code[:16]
+	inv.Created = time.Now().UTC()
+	_, err = db.Exec(INSERT_INVITE, inv.Code, inv.CreatedBy, inv.Created.Format(TIMEFORMAT))
+	return err
+}
+
+func LoadInvites(db *sql.DB) ([]*Invite, error) {
+	rows, err := db.Query(SELECT_ALL_INVITES)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	invites := make([]*Invite, 0)
+	for rows.Next() {
+		inv := new(Invite)
+		var created string
+		if err := rows.Scan(&inv.Code, &inv.CreatedBy, &created, &inv.UsedBy); err != nil {
+			return nil, err
+		}
+		if inv.Created, err = time.Parse(TIMEFORMAT, created); err != nil {
+			return nil, fmt.Errorf("Can't parse date %s: %s", created, err.Error())
+		}
+		invites = append(invites, inv)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return invites, nil
+}
+
+// ClaimInvite reserves an unused invite so it can't be used twice. The
+// invite has to be either bound to the new user with UseInvite or given back
+// with ReleaseInvite.
+func ClaimInvite(db *sql.DB, code string) error {
+	res, err := db.Exec(CLAIM_INVITE, code)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return fmt.Errorf("Invite is unknown or used already")
+	}
+	return nil
+}
+
+func UseInvite(db *sql.DB, code string, userId int64) error {
+	_, err := db.Exec(SET_INVITE_USER, userId, code)
+	return err
+}
+
+func ReleaseInvite(db *sql.DB, code string) error {
+	_, err := db.Exec(SET_INVITE_USER, 0, code)
+	return err
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// A joker doubles points of one prediction. Every user has one joker per stage.
+const JOKER_MULTIPLIER = 2
+
+const (
+	SELECT_STAGE_JOKERS = "SELECT Predictions.match_id FROM Predictions JOIN Matches ON Matches.rowid=Predictions.match_id" +
+		" WHERE Predictions.user_id=? AND Predictions.joker=1 AND Matches.season_id=? AND Matches.date >= ? AND Matches.date <= ?"
+	UPDATE_PREDICTION_JOKER = "UPDATE Predictions SET joker=? WHERE user_id=? AND match_id=?"
+)
+
+var (
+	ErrJokerNoStage = errors.New("Match isn't a part of any stage, joker can't be played")
+	ErrJokerLocked  = errors.New("Joker of the stage is already played on a match that has started")
+)
+
+// stageJokers returns ids of matches of the stage the user played a joker on.
+func stageJokers(db *sql.DB, userId int64, s *Stage) ([]int64, error) {
+	rows, err := db.Query(SELECT_STAGE_JOKERS, userId, s.SeasonId, s.StartDate.Format(TIMEFORMAT), s.EndDate.Format(TIMEFORMAT))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// movableJokers returns matches of the stage of m the user's joker can be
+// taken from. The joker can't be moved once predictions for the match it is played on are locked.
+func movableJokers(db *sql.DB, userId int64, m *Match) ([]int64, error) {
+	stage, err := FindStageByDate(db, m.SeasonId, m.Date)
+	if err == sql.ErrNoRows {
+		return nil, ErrJokerNoStage
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	played, err := stageJokers(db, userId, stage)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range played {
+		if id == m.Id {
+			continue
+		}
+		other, err := LoadMatch(db, id)
+		if err != nil {
+			return nil, err
+		}
+		if other.IsLocked() {
+			return nil, ErrJokerLocked
+		}
+	}
+	return played, nil
+}
+
+// SavePredictionWithJoker stores the prediction and, unless joker is nil,
+// plays the joker of the user on the match or takes it back, all in one
+// transaction. The joker is moved from another match of the stage unless
+// predictions for that match are locked.
+func SavePredictionWithJoker(db *sql.DB, pred *Prediction, m *Match, joker *bool) error {
+	var played []int64
+	if joker != nil && *joker {
+		var err error
+		if played, err = movableJokers(db, pred.UserId, m); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := savePrediction(tx, pred); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if joker != nil {
+		for _, id := range played {
+			if _, err := tx.Exec(UPDATE_PREDICTION_JOKER, false, pred.UserId, id); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if _, err := tx.Exec(UPDATE_PREDICTION_JOKER, *joker, pred.UserId, m.Id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
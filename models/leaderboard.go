@@ -0,0 +1,100 @@
+package models
+
+import (
+	"sort"
+)
+
+type LeaderboardRow struct {
+	Rank        int    `json:"rank"`
+	UserId      int64  `json:"userId"`
+	Name        string `json:"name"`
+	Points      int    `json:"points"`
+	Exact       int    `json:"exact"`
+	Winners     int    `json:"winners"`
+	Predictions int    `json:"predictions"`
+	Futures     int    `json:"futures"`
+}
+
+// BuildLeaderboard ranks users by points collected over the given matches and
+// futures picks. Predictions for matches outside of the list or cancelled are
+// ignored, as well as predictions that aren't revealed yet. Ties are broken by
+// exact hits, then correct winners, then by name and user id, so the order is
+// stable between requests.
+func BuildLeaderboard(users []*User, matches []*Match, predictions []*Prediction, picks []*FuturePick) []*LeaderboardRow {
+	matchesMap := make(map[int64]*Match)
+	for _, m := range matches {
+		// predictions for cancelled matches are void
+		if m.Status == STATUS_CANCELLED {
+			continue
+		}
+		matchesMap[m.Id] = m
+	}
+
+	rowsMap := make(map[int64]*LeaderboardRow)
+	rows := make([]*LeaderboardRow, 0, len(users))
+	for _, u := range users {
+		row := &LeaderboardRow{UserId: u.Id, Name: u.Name}
+		rowsMap[u.Id] = row
+		rows = append(rows, row)
+	}
+
+	for _, pred := range predictions {
+		m, ok := matchesMap[pred.MatchId]
+		if !ok || !m.IsLocked() {
+			continue
+		}
+		row, ok := rowsMap[pred.UserId]
+		if !ok {
+			continue
+		}
+
+		row.Predictions++
+		row.Points += pred.Points
+
+		if !m.IsScored() {
+			continue
+		}
+		outcome, err := Evaluate(m.Result, pred.Score)
+		if err != nil {
+			continue
+		}
+		if outcome.Exact {
+			row.Exact++
+		}
+		if outcome.Winner {
+			row.Winners++
+		}
+	}
+
+	for _, pick := range picks {
+		row, ok := rowsMap[pick.UserId]
+		if !ok {
+			continue
+		}
+		row.Points += pick.Points
+		if pick.Points > 0 {
+			row.Futures++
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		switch {
+		case a.Points != b.Points:
+			return a.Points > b.Points
+		case a.Exact != b.Exact:
+			return a.Exact > b.Exact
+		case a.Winners != b.Winners:
+			return a.Winners > b.Winners
+		case a.Name != b.Name:
+			return a.Name < b.Name
+		}
+		return a.UserId < b.UserId
+	})
+
+	for i, row := range rows {
+		row.Rank = i + 1
+	}
+
+	return rows
+}
@@ -0,0 +1,206 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// League is a private prediction pool. Users join it with the invite code
+// and only see each other's predictions and standings inside it. Members of
+// leagues with HidePredictors set don't show even whether they have predicted
+// a match until predictions for it lock.
+type League struct {
+	Id             int64     `json:"id"`
+	Name           string    `json:"name"`
+	OwnerId        int64     `json:"ownerId"`
+	InviteCode     string    `json:"inviteCode"`
+	Created        time.Time `json:"created"`
+	HidePredictors bool      `json:"hidePredictors"`
+}
+
+const (
+	CREATE_LEAGUES_TABLE        = "CREATE TABLE IF NOT EXISTS Leagues(name, owner_id, invite_code, created, hide_predictors DEFAULT 0)"
+	CREATE_LEAGUE_MEMBERS_TABLE = "CREATE TABLE IF NOT EXISTS LeagueMembers(league_id, user_id, joined, UNIQUE(league_id, user_id))"
+	INSERT_LEAGUE               = "INSERT INTO Leagues(name, owner_id, invite_code, created, hide_predictors) VALUES(?,?,?,?,?)"
+	UPDATE_LEAGUE               = "UPDATE Leagues SET name=?, hide_predictors=? WHERE rowid=?"
+	INSERT_LEAGUE_MEMBER        = "INSERT OR IGNORE INTO LeagueMembers(league_id, user_id, joined) VALUES(?,?,?)"
+	SELECT_ALL_LEAGUES          = "SELECT rowid, name, owner_id, invite_code, created, hide_predictors FROM Leagues"
+	SELECT_LEAGUE_BY_ID         = SELECT_ALL_LEAGUES + " WHERE rowid=?"
+	SELECT_LEAGUE_BY_CODE       = SELECT_ALL_LEAGUES + " WHERE invite_code=?"
+	SELECT_USER_LEAGUES         = SELECT_ALL_LEAGUES + " WHERE rowid IN (SELECT league_id FROM LeagueMembers WHERE user_id=?) ORDER BY name"
+	SELECT_LEAGUE_MEMBERS       = "SELECT user_id FROM LeagueMembers WHERE league_id=?"
+	SELECT_HIDDEN_PREDICTORS    = "SELECT DISTINCT user_id FROM LeagueMembers WHERE league_id IN (SELECT rowid FROM Leagues WHERE hide_predictors=1)"
+)
+
+func InitLeaguesTables(db *sql.DB) error {
+	if _, err := db.Exec(CREATE_LEAGUES_TABLE); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "Leagues", "hide_predictors", "DEFAULT 0"); err != nil {
+		return err
+	}
+	_, err := db.Exec(CREATE_LEAGUE_MEMBERS_TABLE)
+	return err
+}
+
+func scanLeague(row scannable) (*League, error) {
+	l := new(League)
+	var created string
+	if err := row.Scan(&l.Id, &l.Name, &l.OwnerId, &l.InviteCode, &created, &l.HidePredictors); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if l.Created, err = time.Parse(TIMEFORMAT, created); err != nil {
+		return nil, fmt.Errorf("Can't parse date %s: %s", created, err.Error())
+	}
+	return l, nil
+}
+
+// CreateLeague stores a new league with a fresh invite code. The owner becomes its first member.
+func CreateLeague(db *sql.DB, l *League) error {
+	l.Name = strings.TrimSpace(l.Name)
+	if len(l.Name) == 0 {
+		return fmt.Errorf("League name is empty")
+	}
+	if l.OwnerId == 0 {
+		return fmt.Errorf("Owner ID is null")
+	}
+
+	code, err := generateToken()
+	if err != nil {
+		return fmt.Errorf("Can't generate invite code: %s", err.Error())
+	}
+
+	l.InviteCode = code[:12]
+	l.Created = time.Now().UTC()
+
+	result, err := db.Exec(INSERT_LEAGUE, l.Name, l.OwnerId, l.InviteCode, l.Created.Format(TIMEFORMAT), l.HidePredictors)
+	if err != nil {
+		return err
+	}
+	l.Id, _ = result.LastInsertId()
+
+	return addLeagueMember(db, l.Id, l.OwnerId)
+}
+
+func LoadLeague(db *sql.DB, id int64) (*League, error) {
+	return scanLeague(db.QueryRow(SELECT_LEAGUE_BY_ID, id))
+}
+
+// SaveLeague updates the name and the options of the league.
+func SaveLeague(db *sql.DB, l *League) error {
+	l.Name = strings.TrimSpace(l.Name)
+	if len(l.Name) == 0 {
+		return fmt.Errorf("League name is empty")
+	}
+
+	res, err := db.Exec(UPDATE_LEAGUE, l.Name, l.HidePredictors, l.Id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func addLeagueMember(db *sql.DB, leagueId int64, userId int64) error {
+	_, err := db.Exec(INSERT_LEAGUE_MEMBER, leagueId, userId, time.Now().UTC().Format(TIMEFORMAT))
+	return err
+}
+
+// JoinLeague adds the user to the league with the invite code. Joining a league twice does nothing.
+func JoinLeague(db *sql.DB, code string, userId int64) (*League, error) {
+	l, err := scanLeague(db.QueryRow(SELECT_LEAGUE_BY_CODE, strings.TrimSpace(code)))
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, fmt.Errorf("Unknown invite code")
+	case err != nil:
+		return nil, err
+	}
+
+	if err := addLeagueMember(db, l.Id, userId); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func LoadUserLeagues(db *sql.DB, userId int64) ([]*League, error) {
+	rows, err := db.Query(SELECT_USER_LEAGUES, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	leagues := make([]*League, 0)
+	for rows.Next() {
+		l, err := scanLeague(rows)
+		if err != nil {
+			return nil, err
+		}
+		leagues = append(leagues, l)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return leagues, nil
+}
+
+// LoadLeagueMembers returns ids of league members as a set.
+func LoadLeagueMembers(db *sql.DB, leagueId int64) (map[int64]bool, error) {
+	rows, err := db.Query(SELECT_LEAGUE_MEMBERS, leagueId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	members := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		members[id] = true
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return members, nil
+}
+
+// LoadHiddenPredictors returns ids of members of leagues that hide who has
+// predicted a match, as a set.
+func LoadHiddenPredictors(db *sql.DB) (map[int64]bool, error) {
+	rows, err := db.Query(SELECT_HIDDEN_PREDICTORS)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	users := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		users[id] = true
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return users, nil
+}
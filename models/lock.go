@@ -0,0 +1,106 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Lock policies tell when predictions for matches of a stage close. Matches
+// of LOCK_MATCH stages lock LockMinutes before their own start, all matches
+// of LOCK_STAGE stages lock LockMinutes before the first match of the stage.
+const (
+	LOCK_MATCH = "match"
+	LOCK_STAGE = "stage"
+)
+
+const SELECT_FIRST_STAGE_MATCH = "SELECT MIN(date) FROM Matches WHERE season_id=? AND date >= ? AND date <= ? AND status<>'cancelled'"
+
+func validateLockPolicy(s *Stage) error {
+	if len(s.LockMode) == 0 {
+		s.LockMode = LOCK_MATCH
+	}
+	if s.LockMode != LOCK_MATCH && s.LockMode != LOCK_STAGE {
+		return fmt.Errorf("Unknown lock mode %q", s.LockMode)
+	}
+	if s.LockMinutes < 0 {
+		return fmt.Errorf("Lock minutes can't be negative")
+	}
+	return nil
+}
+
+// firstMatchDate returns the start of the first match of the stage, or zero time if it has none.
+func firstMatchDate(db *sql.DB, s *Stage) (time.Time, error) {
+	var date sql.NullString
+	err := db.QueryRow(SELECT_FIRST_STAGE_MATCH, s.SeasonId, s.StartDate.Format(TIMEFORMAT), s.EndDate.Format(TIMEFORMAT)).Scan(&date)
+	if err != nil || !date.Valid {
+		return time.Time{}, err
+	}
+	return time.Parse(TIMEFORMAT, date.String)
+}
+
+// stageOf finds the stage the match is played in, the latest starting one
+// if several match, like FindStageByDate does.
+func stageOf(stages []*Stage, m *Match) *Stage {
+	var found *Stage
+	for _, s := range stages {
+		if s.SeasonId != m.SeasonId || m.Date.Before(s.StartDate) || m.Date.After(s.EndDate) {
+			continue
+		}
+		if found == nil || s.StartDate.After(found.StartDate) {
+			found = s
+		}
+	}
+	return found
+}
+
+// attachLocks computes when predictions for the matches close according to
+// the lock policies of their stages. Matches outside of stages lock at start.
+func attachLocks(db *sql.DB, matches []*Match) error {
+	stages := make([]*Stage, 0)
+	seasons := make(map[int64]bool)
+	for _, m := range matches {
+		m.LockAt = m.Date
+		if seasons[m.SeasonId] {
+			continue
+		}
+		seasons[m.SeasonId] = true
+
+		seasonStages, err := LoadStagesBySeason(db, m.SeasonId)
+		if err != nil {
+			return err
+		}
+		stages = append(stages, seasonStages...)
+	}
+
+	firstMatches := make(map[int64]time.Time)
+	for _, m := range matches {
+		s := stageOf(stages, m)
+		if s == nil {
+			continue
+		}
+
+		lockAt := m.Date
+		if s.LockMode == LOCK_STAGE {
+			first, ok := firstMatches[s.Id]
+			if !ok {
+				var err error
+				if first, err = firstMatchDate(db, s); err != nil {
+					return err
+				}
+				firstMatches[s.Id] = first
+			}
+			if !first.IsZero() && first.Before(lockAt) {
+				lockAt = first
+			}
+		}
+		m.LockAt = lockAt.Add(-time.Duration(s.LockMinutes) * time.Minute)
+	}
+	return nil
+}
+
+// IsLocked tells whether predictions for the match are closed by the lock
+// policy. Predictions of other users are revealed at the same moment.
+func (m *Match) IsLocked() bool {
+	return !time.Now().UTC().Before(m.LockAt)
+}
@@ -0,0 +1,227 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const (
+	MODE_CONTROL = "control"
+	MODE_HYBRID  = "hybrid"
+	MODE_ASSAULT = "assault"
+	MODE_ESCORT  = "escort"
+)
+
+// MatchMap is a map played in a match. Empty Winner means the map was a draw.
+type MatchMap struct {
+	Number int    `json:"number"`
+	Name   string `json:"name"`
+	Mode   string `json:"mode"`
+	Winner string `json:"winner"`
+}
+
+// MapPrediction is a guess of who wins a map of a match, a bonus to the score prediction.
+type MapPrediction struct {
+	Number int    `json:"number"`
+	Winner string `json:"winner"`
+}
+
+const (
+	CREATE_MATCH_MAPS_TABLE      = "CREATE TABLE IF NOT EXISTS MatchMaps(match_id, number, name, mode, winner)"
+	CREATE_MAP_PREDICTIONS_TABLE = "CREATE TABLE IF NOT EXISTS MapPredictions(user_id, match_id, number, winner)"
+	SELECT_MAPS_IN_MATCHES_RANGE = "SELECT match_id, number, name, mode, winner FROM MatchMaps WHERE match_id >= ? AND match_id <= ? ORDER BY match_id, number"
+	INSERT_MATCH_MAP             = "INSERT INTO MatchMaps(match_id, number, name, mode, winner) VALUES(?,?,?,?,?)"
+	DELETE_MATCH_MAPS            = "DELETE FROM MatchMaps WHERE match_id=?"
+	SELECT_MAP_PREDICTIONS_RANGE = "SELECT user_id, match_id, number, winner FROM MapPredictions WHERE match_id >= ? AND match_id <= ? ORDER BY number"
+	INSERT_MAP_PREDICTION        = "INSERT INTO MapPredictions(user_id, match_id, number, winner) VALUES(?,?,?,?)"
+	DELETE_USER_MAP_PREDICTIONS  = "DELETE FROM MapPredictions WHERE user_id=? AND match_id=?"
+	DELETE_MATCH_MAP_PREDICTIONS = "DELETE FROM MapPredictions WHERE match_id=?"
+)
+
+func InitMapsTables(db *sql.DB) error {
+	if _, err := db.Exec(CREATE_MATCH_MAPS_TABLE); err != nil {
+		return err
+	}
+	_, err := db.Exec(CREATE_MAP_PREDICTIONS_TABLE)
+	return err
+}
+
+func validateMapMode(mode string) error {
+	switch mode {
+	case MODE_CONTROL, MODE_HYBRID, MODE_ASSAULT, MODE_ESCORT:
+		return nil
+	}
+	return scoreError("Unknown map mode %q", mode)
+}
+
+// validateMaps checks that the maps could be played in the match and agree
+// with its result. Maps are numbered in the order they are given.
+func validateMaps(m *Match, maps []*MatchMap) error {
+	if len(maps) > m.Format.BestOf {
+		return scoreError("There can't be more than %d maps in a best-of-%d", m.Format.BestOf, m.Format.BestOf)
+	}
+
+	var wins [2]int
+	for i, mp := range maps {
+		mp.Number = i + 1
+		if err := validateMapMode(mp.Mode); err != nil {
+			return err
+		}
+		switch mp.Winner {
+		case "":
+		case m.Teams[0]:
+			wins[0]++
+		case m.Teams[1]:
+			wins[1]++
+		default:
+			return scoreError("Map %d winner %s doesn't play in the match", mp.Number, mp.Winner)
+		}
+	}
+
+	if len(m.Result) == 0 {
+		return nil
+	}
+	score, err := ParseScore(m.Result)
+	if err != nil {
+		return err
+	}
+	if score.A != wins[0] || score.B != wins[1] {
+		return scoreError("Maps won %d:%d don't match the result %s", wins[0], wins[1], score)
+	}
+	return nil
+}
+
+// ValidateMapPredictions checks that predicted maps can be played in the match.
+func (m *Match) ValidateMapPredictions(maps []*MapPrediction) error {
+	seen := make(map[int]bool)
+	for _, mp := range maps {
+		if mp.Number < 1 || mp.Number > m.Format.BestOf {
+			return scoreError("Map %d can't be played in a best-of-%d", mp.Number, m.Format.BestOf)
+		}
+		if seen[mp.Number] {
+			return scoreError("Map %d is predicted twice", mp.Number)
+		}
+		seen[mp.Number] = true
+		if mp.Winner != m.Teams[0] && mp.Winner != m.Teams[1] {
+			return scoreError("Map %d winner %s doesn't play in the match", mp.Number, mp.Winner)
+		}
+	}
+	return nil
+}
+
+func saveMatchMaps(tx *sql.Tx, matchId int64, maps []*MatchMap) error {
+	if _, err := tx.Exec(DELETE_MATCH_MAPS, matchId); err != nil {
+		return err
+	}
+	for _, mp := range maps {
+		if _, err := tx.Exec(INSERT_MATCH_MAP, matchId, mp.Number, mp.Name, mp.Mode, mp.Winner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveMapPredictions replaces map predictions of the user for the match.
+func saveMapPredictions(q queryer, userId int64, matchId int64, maps []*MapPrediction) error {
+	if _, err := q.Exec(DELETE_USER_MAP_PREDICTIONS, userId, matchId); err != nil {
+		return err
+	}
+	for _, mp := range maps {
+		if _, err := q.Exec(INSERT_MAP_PREDICTION, userId, matchId, mp.Number, mp.Winner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchesRange(matches []*Match) (int64, int64) {
+	minId, maxId := matches[0].Id, matches[0].Id
+	for _, m := range matches {
+		if minId > m.Id {
+			minId = m.Id
+		}
+		if maxId < m.Id {
+			maxId = m.Id
+		}
+	}
+	return minId, maxId
+}
+
+// attachMaps loads maps played in the matches.
+func attachMaps(db *sql.DB, matches []*Match) error {
+	if len(matches) == 0 {
+		return nil
+	}
+
+	matchesMap := make(map[int64]*Match)
+	for _, m := range matches {
+		matchesMap[m.Id] = m
+	}
+
+	minId, maxId := matchesRange(matches)
+	rows, err := db.Query(SELECT_MAPS_IN_MATCHES_RANGE, minId, maxId)
+	if err != nil {
+		return fmt.Errorf("Can't load maps: %s", err.Error())
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var matchId int64
+		mp := new(MatchMap)
+		if err := rows.Scan(&matchId, &mp.Number, &mp.Name, &mp.Mode, &mp.Winner); err != nil {
+			return fmt.Errorf("Can't parse maps: %s", err.Error())
+		}
+		if m, ok := matchesMap[matchId]; ok {
+			m.Maps = append(m.Maps, mp)
+		}
+	}
+	return rows.Err()
+}
+
+// attachMapPredictions loads map predictions made along with the predictions.
+func attachMapPredictions(db *sql.DB, predictions []*Prediction, minId int64, maxId int64) error {
+	type key struct {
+		userId  int64
+		matchId int64
+	}
+	predictionsMap := make(map[key]*Prediction)
+	for _, pred := range predictions {
+		predictionsMap[key{pred.UserId, pred.MatchId}] = pred
+	}
+
+	rows, err := db.Query(SELECT_MAP_PREDICTIONS_RANGE, minId, maxId)
+	if err != nil {
+		return fmt.Errorf("Can't load map predictions: %s", err.Error())
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var k key
+		mp := new(MapPrediction)
+		if err := rows.Scan(&k.userId, &k.matchId, &mp.Number, &mp.Winner); err != nil {
+			return fmt.Errorf("Can't parse map predictions: %s", err.Error())
+		}
+		if pred, ok := predictionsMap[k]; ok {
+			pred.Maps = append(pred.Maps, mp)
+		}
+	}
+	return rows.Err()
+}
+
+// CountMapWinners returns how many of the predicted map winners are correct.
+func CountMapWinners(maps []*MatchMap, predicted []*MapPrediction) int {
+	winners := make(map[int]string)
+	for _, mp := range maps {
+		winners[mp.Number] = mp.Winner
+	}
+
+	count := 0
+	for _, mp := range predicted {
+		if winner, ok := winners[mp.Number]; ok && winner == mp.Winner {
+			count++
+		}
+	}
+	return count
+}
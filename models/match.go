@@ -2,37 +2,144 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Match struct {
-	Id          int64         `json:"id"`
-	Teams       [2]string     `json:"teams"`
-	Date        time.Time     `json:"date"`
-	Result      string        `json:"result"`
-	Predictions []*Prediction `json:"predictions"`
+	Id                int64         `json:"id"`
+	Teams             [2]string     `json:"teams"`
+	Date              time.Time     `json:"date"`
+	Result            string        `json:"result"`
+	SeasonId          int64         `json:"seasonId"`
+	Status            string        `json:"status"`
+	OriginalDate      *time.Time    `json:"originalDate,omitempty"`
+	PredictionsLocked bool          `json:"predictionsLocked"`
+	LockAt            time.Time     `json:"lockAt"`
+	Format            MatchFormat   `json:"format"`
+	Maps              []*MatchMap   `json:"maps"`
+	Predictions       []*Prediction `json:"predictions"`
+	Stats             *MatchStats   `json:"stats,omitempty"`
 }
 
 const (
-	TIMEFORMAT           = "2006-01-02T15:04:05Z0700"
-	CREATE_MATCHES_TABLE = "CREATE TABLE IF NOT EXISTS Matches(team_a, team_b, date, result)"
-	SELECT_ALL_MATCHES   = "SELECT rowid, team_a, team_b, date, result FROM Matches ORDER BY date ASC"
-	SELECT_MATCH_BY_ID   = "SELECT rowid, team_a, team_b, date, result FROM Matches WHERE rowid=?"
-	SELECT_STAGE_MATCHES = "SELECT rowid, team_a, team_b, date, result FROM Matches WHERE date >= ? AND date <= ?"
+	STATUS_SCHEDULED = "scheduled"
+	STATUS_LIVE      = "live"
+	STATUS_FINISHED  = "finished"
+	STATUS_POSTPONED = "postponed"
+	STATUS_CANCELLED = "cancelled"
 )
 
+const (
+	TIMEFORMAT               = "2006-01-02T15:04:05Z0700"
+	CREATE_MATCHES_TABLE     = "CREATE TABLE IF NOT EXISTS Matches(team_a, team_b, date, result, season_id, status DEFAULT 'scheduled', original_date DEFAULT '', predictions_locked DEFAULT 0, best_of DEFAULT 5, draws DEFAULT 0)"
+	SELECT_MATCHES           = "SELECT rowid, team_a, team_b, date, result, season_id, status, original_date, predictions_locked, best_of, draws FROM Matches"
+	SELECT_ALL_MATCHES       = SELECT_MATCHES + " ORDER BY date ASC"
+	SELECT_MATCH_BY_ID       = SELECT_MATCHES + " WHERE rowid=?"
+	SELECT_STAGE_MATCHES     = SELECT_MATCHES + " WHERE date >= ? AND date <= ? AND season_id=?"
+	SELECT_SEASON_MATCHES    = SELECT_MATCHES + " WHERE season_id=? ORDER BY date ASC"
+	ASSIGN_MATCHES_TO_SEASON = "UPDATE Matches SET season_id=(SELECT MIN(rowid) FROM Seasons) WHERE season_id=0"
+	DELETE_MATCH             = "DELETE FROM Matches WHERE rowid=?"
+	DELETE_MATCH_PREDICTIONS = "DELETE FROM Predictions WHERE match_id=?"
+	COUNT_MATCH_PREDICTIONS  = "SELECT COUNT(*) FROM Predictions WHERE match_id=?"
+)
+
+var (
+	ErrMatchHasPredictions = errors.New("Match has predictions")
+	ErrResultBeforeLock    = errors.New("Result can't be set before predictions for the match are locked")
+)
+
+func ValidateMatchStatus(status string) error {
+	switch status {
+	case STATUS_SCHEDULED, STATUS_LIVE, STATUS_FINISHED, STATUS_POSTPONED, STATUS_CANCELLED:
+		return nil
+	}
+	return fmt.Errorf("Unknown match status %q", status)
+}
+
+// InitMatchesTable creates the matches table. Matches from before seasons were
+// introduced are assigned to the first season. Seasons must be initialized first.
 func InitMatchesTable(db *sql.DB) error {
-	_, err := db.Exec(CREATE_MATCHES_TABLE)
+	if _, err := db.Exec(CREATE_MATCHES_TABLE); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "Matches", "season_id", "DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "Matches", "status", "DEFAULT '"+STATUS_SCHEDULED+"'"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "Matches", "original_date", "DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "Matches", "predictions_locked", "DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "Matches", "best_of", "DEFAULT "+strconv.Itoa(DEFAULT_BEST_OF)); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "Matches", "draws", "DEFAULT 0"); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(ASSIGN_MATCHES_TO_SEASON)
 	return err
 }
 
+// checkSeasonTeams makes sure the season can be changed and the teams play in it.
+func checkSeasonTeams(db *sql.DB, seasonId int64, teams ...string) error {
+	season, err := LoadSeason(db, seasonId)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("No season with id: %d", seasonId)
+	}
+	if err != nil {
+		return err
+	}
+	if season.Archived {
+		return ErrSeasonArchived
+	}
+
+	if err := ValidateTeams(db, teams...); err != nil {
+		return err
+	}
+	return validateSeasonTeams(db, seasonId, teams...)
+}
+
 func AddMatch(db *sql.DB, m *Match) error {
 	if len(m.Teams[0]) == 0 || len(m.Teams[1]) == 0 {
 		return fmt.Errorf("There should be 2 teams")
 	}
+	if err := checkSeasonTeams(db, m.SeasonId, m.Teams[0], m.Teams[1]); err != nil {
+		return err
+	}
+	if len(m.Status) == 0 {
+		m.Status = STATUS_SCHEDULED
+	}
+	if err := ValidateMatchStatus(m.Status); err != nil {
+		return err
+	}
+	if m.Format.BestOf == 0 {
+		m.Format.BestOf = DEFAULT_BEST_OF
+	}
+	if err := m.Format.Check(); err != nil {
+		return err
+	}
+	if len(m.Result) != 0 {
+		score, err := m.Format.ParseScore(m.Result)
+		if err != nil {
+			return err
+		}
+		m.Result = score.String()
+	}
+	if err := checkResultTime(db, m); err != nil {
+		return err
+	}
 	date := m.Date.UTC().Format(TIMEFORMAT)
-	result, err := db.Exec("INSERT INTO Matches(team_a, team_b, date, result) VALUES(?,?,?,?)", m.Teams[0], m.Teams[1], date, m.Result)
+	result, err := db.Exec("INSERT INTO Matches(team_a, team_b, date, result, season_id, status, best_of, draws) VALUES(?,?,?,?,?,?,?,?)",
+		m.Teams[0], m.Teams[1], date, m.Result, m.SeasonId, m.Status, m.Format.BestOf, m.Format.Draws)
 
 	if err == nil {
 		m.Id, _ = result.LastInsertId()
@@ -45,8 +152,21 @@ func SaveMatch(db *sql.DB, m *Match) error {
 		return fmt.Errorf("MatchId is null")
 	}
 
-	fields := make([]string, 0, 4)
-	values := make([]string, 0, 4)
+	old, err := LoadMatch(db, m.Id)
+	if err != nil {
+		return err
+	}
+
+	teams := make([]string, 0, 2)
+	if len(m.Teams[0]) != 0 && len(m.Teams[1]) != 0 {
+		teams = append(teams, m.Teams[0], m.Teams[1])
+	}
+	if err := checkSeasonTeams(db, old.SeasonId, teams...); err != nil {
+		return err
+	}
+
+	fields := make([]string, 0, 7)
+	values := make([]interface{}, 0, 7)
 
 	if len(m.Teams[0]) != 0 && len(m.Teams[1]) != 0 {
 		fields = append(fields, "team_a")
@@ -57,25 +177,123 @@ func SaveMatch(db *sql.DB, m *Match) error {
 
 	if !m.Date.IsZero() {
 		fields = append(fields, "date")
-		values = append(values, m.Date.Format(TIMEFORMAT))
+		values = append(values, m.Date.UTC().Format(TIMEFORMAT))
+	}
+
+	// the format is only changed if the number of maps is given
+	format := old.Format
+	if m.Format.BestOf != 0 {
+		if err := m.Format.Check(); err != nil {
+			return err
+		}
+		format = m.Format
+		fields = append(fields, "best_of")
+		values = append(values, format.BestOf)
+		fields = append(fields, "draws")
+		values = append(values, format.Draws)
 	}
 
 	if len(m.Result) != 0 {
+		score, err := format.ParseScore(m.Result)
+		if err != nil {
+			return err
+		}
 		fields = append(fields, "result")
-		values = append(values, m.Result)
+		values = append(values, score.String())
+	}
+
+	if len(m.Status) != 0 {
+		if err := ValidateMatchStatus(m.Status); err != nil {
+			return err
+		}
+		fields = append(fields, "status")
+		values = append(values, m.Status)
+
+		// predictions may only stay locked while the match is postponed
+		fields = append(fields, "predictions_locked")
+		values = append(values, m.Status == STATUS_POSTPONED && m.PredictionsLocked)
+
+		// the first slot of a postponed match is kept to know what predictions were made for
+		if m.Status == STATUS_POSTPONED && old.OriginalDate == nil {
+			fields = append(fields, "original_date")
+			values = append(values, old.Date.UTC().Format(TIMEFORMAT))
+		}
 	}
 
+	// the result and maps are checked against the match as it is going to be
+	updated := *old
+	updated.Format = format
+	if len(teams) != 0 {
+		updated.Teams = m.Teams
+	}
+	if !m.Date.IsZero() {
+		updated.Date = m.Date
+	}
+	if len(m.Result) != 0 {
+		updated.Result = m.Result
+	}
+	if len(m.Status) != 0 {
+		updated.Status = m.Status
+	}
+	if err := checkResultTime(db, &updated); err != nil {
+		return err
+	}
+	// a new format must fit the result and maps the match already has
+	if m.Format.BestOf != 0 && len(m.Result) == 0 && len(old.Result) != 0 {
+		if _, err := format.ParseScore(old.Result); err != nil {
+			return err
+		}
+	}
+	if m.Format.BestOf != 0 && m.Maps == nil && len(old.Maps) != 0 {
+		if err := validateMaps(&updated, old.Maps); err != nil {
+			return err
+		}
+	}
+	if m.Maps != nil {
+		if err := validateMaps(&updated, m.Maps); err != nil {
+			return err
+		}
+	}
+
+	if len(fields) == 0 && m.Maps == nil {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if len(fields) != 0 {
+		if err := updateMatch(tx, m.Id, fields, values); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if m.Maps != nil {
+		if err := saveMatchMaps(tx, m.Id, m.Maps); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func updateMatch(tx *sql.Tx, id int64, fields []string, values []interface{}) error {
 	query := "UPDATE Matches SET "
 	for i, val := range fields {
-		query += val + "='" + values[i] + "'"
+		query += val + "=?"
 		if i != len(fields)-1 {
 			query += ", "
 		}
 	}
 
 	query += " WHERE rowid=?"
+	values = append(values, id)
 
-	res, err := db.Exec(query, m.Id)
+	res, err := tx.Exec(query, values...)
 	if err != nil {
 		return err
 	}
@@ -92,58 +310,277 @@ func SaveMatch(db *sql.DB, m *Match) error {
 	return nil
 }
 
-func LoadMatchesByStage(db *sql.DB, s *Stage) ([]*Match, error) {
-	rows, err := db.Query(SELECT_STAGE_MATCHES, s.StartDate.Format(TIMEFORMAT), s.EndDate.Format(TIMEFORMAT))
+// DeleteMatch removes a match. A match with predictions is only removed
+// together with them if cascade is set, ErrMatchHasPredictions is returned otherwise.
+func DeleteMatch(db *sql.DB, id int64, cascade bool) error {
+	var count int
+	if err := db.QueryRow(COUNT_MATCH_PREDICTIONS, id).Scan(&count); err != nil {
+		return err
+	}
+	if count != 0 && !cascade {
+		return ErrMatchHasPredictions
+	}
+
+	tx, err := db.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	defer rows.Close()
+	for _, query := range []string{DELETE_MATCH_PREDICTIONS, DELETE_MATCH_MAP_PREDICTIONS, DELETE_MATCH_MAPS, DELETE_MATCH_HISTORY} {
+		if _, err := tx.Exec(query, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 
-	matches := make([]*Match, 0)
+	res, err := tx.Exec(DELETE_MATCH, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
-	for rows.Next() {
-		var id int64
-		var teamA, teamB, date, result string
-		if err := rows.Scan(&id, &teamA, &teamB, &date, &result); err != nil {
+	rows, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if rows != 1 {
+		tx.Rollback()
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+// scanMatch reads a match row. Stored status is refined with time and result:
+// a scheduled match that has started is live, a match with a result is finished.
+func scanMatch(row scannable) (*Match, error) {
+	m := new(Match)
+	var date, originalDate string
+
+	err := row.Scan(&m.Id, &m.Teams[0], &m.Teams[1], &date, &m.Result, &m.SeasonId, &m.Status, &originalDate, &m.PredictionsLocked,
+		&m.Format.BestOf, &m.Format.Draws)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Date, err = time.Parse(TIMEFORMAT, date); err != nil {
+		return nil, err
+	}
+	if len(originalDate) != 0 {
+		parsed, err := time.Parse(TIMEFORMAT, originalDate)
+		if err != nil {
 			return nil, err
 		}
+		m.OriginalDate = &parsed
+	}
+
+	switch {
+	case m.Status == STATUS_CANCELLED:
+	case len(m.Result) != 0:
+		m.Status = STATUS_FINISHED
+	case m.Status == STATUS_SCHEDULED && m.IsStarted():
+		m.Status = STATUS_LIVE
+	}
 
-		parsedDate, err := time.Parse(TIMEFORMAT, date)
+	return m, nil
+}
+
+func loadMatches(db *sql.DB, rows *sql.Rows) ([]*Match, error) {
+	matches := make([]*Match, 0)
+
+	for rows.Next() {
+		m, err := scanMatch(rows)
 		if err != nil {
 			return nil, err
 		}
-		matches = append(matches, &Match{Id: id, Teams: [2]string{teamA, teamB}, Date: parsedDate, Result: result})
+		matches = append(matches, m)
 	}
 	if rows.Err() != nil {
 		return nil, rows.Err()
 	}
+	rows.Close()
 
+	if err := attachMaps(db, matches); err != nil {
+		return nil, err
+	}
+	if err := attachLocks(db, matches); err != nil {
+		return nil, err
+	}
 	return matches, nil
 }
 
-func LoadMatch(db *sql.DB, id int64) (*Match, error) {
-	row := db.QueryRow(SELECT_MATCH_BY_ID, id)
+func LoadMatches(db *sql.DB) ([]*Match, error) {
+	rows, err := db.Query(SELECT_ALL_MATCHES)
+	if err != nil {
+		return nil, err
+	}
 
-	m := new(Match)
-	var date string
+	defer rows.Close()
 
-	err := row.Scan(&m.Id, &m.Teams[0], &m.Teams[1], &date, &m.Result)
-	switch {
-	case err == sql.ErrNoRows:
-		return nil, fmt.Errorf("No match with id: ", id)
-	case err != nil:
+	return loadMatches(db, rows)
+}
+
+func LoadMatchesByStage(db *sql.DB, s *Stage) ([]*Match, error) {
+	rows, err := db.Query(SELECT_STAGE_MATCHES, s.StartDate.Format(TIMEFORMAT), s.EndDate.Format(TIMEFORMAT), s.SeasonId)
+	if err != nil {
 		return nil, err
 	}
 
-	m.Date, err = time.Parse(TIMEFORMAT, date)
+	defer rows.Close()
+
+	return loadMatches(db, rows)
+}
+
+func LoadMatchesBySeason(db *sql.DB, seasonId int64) ([]*Match, error) {
+	rows, err := db.Query(SELECT_SEASON_MATCHES, seasonId)
 	if err != nil {
 		return nil, err
 	}
 
+	defer rows.Close()
+
+	return loadMatches(db, rows)
+}
+
+func LoadMatch(db *sql.DB, id int64) (*Match, error) {
+	m, err := scanMatch(db.QueryRow(SELECT_MATCH_BY_ID, id))
+	if err != nil {
+		return nil, err
+	}
+	if err := attachMaps(db, []*Match{m}); err != nil {
+		return nil, err
+	}
+	if err := attachLocks(db, []*Match{m}); err != nil {
+		return nil, err
+	}
 	return m, nil
 }
 
+// MATCH_UPCOMING filters matches that are yet to be played, scheduled or postponed.
+const MATCH_UPCOMING = "upcoming"
+
+// MatchFilter narrows down a match search. Zero values don't filter.
+type MatchFilter struct {
+	SeasonId int64
+	From     time.Time
+	To       time.Time
+	Team     string
+	Status   string
+	Limit    int
+	Offset   int
+}
+
+func (f *MatchFilter) where() (string, []interface{}, error) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	if f.SeasonId != 0 {
+		conditions = append(conditions, "season_id=?")
+		args = append(args, f.SeasonId)
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, "date >= ?")
+		args = append(args, f.From.UTC().Format(TIMEFORMAT))
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "date <= ?")
+		args = append(args, f.To.UTC().Format(TIMEFORMAT))
+	}
+	if len(f.Team) != 0 {
+		conditions = append(conditions, "(team_a=? OR team_b=?)")
+		args = append(args, f.Team, f.Team)
+	}
+
+	now := time.Now().UTC().Format(TIMEFORMAT)
+	switch f.Status {
+	case "":
+	case MATCH_UPCOMING:
+		conditions = append(conditions, "status IN ('scheduled','postponed') AND date > ? AND result=''")
+		args = append(args, now)
+	case STATUS_LIVE:
+		conditions = append(conditions, "result='' AND (status='live' OR (status='scheduled' AND date <= ?))")
+		args = append(args, now)
+	case STATUS_FINISHED:
+		conditions = append(conditions, "status<>'cancelled' AND (result<>'' OR status='finished')")
+	case STATUS_POSTPONED:
+		conditions = append(conditions, "status='postponed' AND result=''")
+	case STATUS_CANCELLED:
+		conditions = append(conditions, "status='cancelled'")
+	default:
+		return "", nil, fmt.Errorf("Unknown match status %q", f.Status)
+	}
+
+	if len(conditions) == 0 {
+		return "", args, nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+// FindMatches returns a page of matches passing the filter ordered by date,
+// and the total number of such matches.
+func FindMatches(db *sql.DB, f *MatchFilter) ([]*Match, int, error) {
+	where, args, err := f.where()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM Matches"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := SELECT_MATCHES + where + " ORDER BY date ASC, rowid ASC"
+	if f.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, f.Limit, f.Offset)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer rows.Close()
+
+	matches, err := loadMatches(db, rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return matches, total, nil
+}
+
+// AcceptsPredictions tells whether predictions for the match can still be made or changed.
+func (m *Match) AcceptsPredictions() bool {
+	switch m.Status {
+	case STATUS_LIVE, STATUS_FINISHED, STATUS_CANCELLED:
+		return false
+	}
+	return !m.IsLocked() && !m.PredictionsLocked
+}
+
+// IsScored tells whether predictions for the match earn points: the match has
+// a result, isn't cancelled and predictions for it are locked. Scoring,
+// leaderboards, standings and stats all count the same matches.
+func (m *Match) IsScored() bool {
+	return len(m.Result) != 0 && m.Status != STATUS_CANCELLED && m.IsLocked()
+}
+
+// checkResultTime makes sure a result is only set once predictions for the
+// match are locked, so points never show up before predictions are revealed.
+func checkResultTime(db *sql.DB, m *Match) error {
+	if len(m.Result) == 0 || m.Status == STATUS_CANCELLED {
+		return nil
+	}
+	if err := attachLocks(db, []*Match{m}); err != nil {
+		return err
+	}
+	if !m.IsLocked() {
+		return ErrResultBeforeLock
+	}
+	return nil
+}
+
 func (m *Match) IsStarted() bool {
 	return m.Date.Before(time.Now().UTC())
 }
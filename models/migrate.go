@@ -0,0 +1,42 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// addColumnIfMissing extends an existing table with a new column. Tables are
+// created with CREATE TABLE IF NOT EXISTS, so databases created before the
+// column was introduced need it added explicitly.
+func addColumnIfMissing(db *sql.DB, table string, column string, definition string) error {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name string
+		var columnType, defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return fmt.Errorf("Can't read columns of %s: %s", table, err.Error())
+		}
+		if name == column {
+			found = true
+		}
+	}
+	rows.Close()
+
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	if found {
+		return nil
+	}
+
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
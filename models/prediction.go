@@ -9,34 +9,78 @@ type Prediction struct {
 	UserId  int64  `json:"userId"`
 	MatchId int64  `json:"-"`
 	Score   string `json:"score"`
+	Points  int    `json:"points"`
+	Joker   bool   `json:"joker"`
+
+	// Hidden predictions are shown to other users without any details until predictions lock
+	Hidden bool `json:"hidden"`
+
+	Maps []*MapPrediction `json:"maps,omitempty"`
+
+	// Ip is the address the prediction is sent from, it goes to the history only
+	Ip string `json:"-"`
 }
 
 const (
 	ADD                                 = "INSERT INTO Predictions(user_id, match_id, score) VALUES($1,$2,$3)"
 	UPDATE                              = "UPDATE Predictions SET score=$1 WHERE user_id=$2 AND match_id=$3"
-	SELECT_ALL_PREDICTIONS              = "SELECT user_id, match_id, score FROM Predictions"
+	SELECT_ALL_PREDICTIONS              = "SELECT user_id, match_id, score, points, joker FROM Predictions"
 	SELECT_PREDICTIONS_IN_MATCHES_RANGE = SELECT_ALL_PREDICTIONS + " WHERE match_id >= ? AND match_id <= ?"
+	SELECT_PREDICTIONS_BY_MATCH         = SELECT_ALL_PREDICTIONS + " WHERE match_id = ?"
 )
 
 func InitPredictionsTable(db *sql.DB) error {
-	_, err := db.Exec("CREATE TABLE IF NOT EXISTS Predictions(user_id, match_id, score)")
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS Predictions(user_id, match_id, score, points DEFAULT 0, joker DEFAULT 0)")
+	if err != nil {
+		return err
+	}
 
-	return err
+	if err := addColumnIfMissing(db, "Predictions", "points", "DEFAULT 0"); err != nil {
+		return err
+	}
+	return addColumnIfMissing(db, "Predictions", "joker", "DEFAULT 0")
 }
 
-func createPrediction(db *sql.DB, pred *Prediction) error {
-	_, err := db.Exec(ADD, pred.UserId, pred.MatchId, pred.Score)
+// queryer is either the database or a transaction.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func createPrediction(q queryer, pred *Prediction) error {
+	_, err := q.Exec(ADD, pred.UserId, pred.MatchId, pred.Score)
 
 	return err
 }
 
-func updatePrediction(db *sql.DB, pred *Prediction) error {
-	_, err := db.Exec(UPDATE, pred.Score, pred.UserId, pred.MatchId)
+func updatePrediction(q queryer, pred *Prediction) error {
+	_, err := q.Exec(UPDATE, pred.Score, pred.UserId, pred.MatchId)
 
 	return err
 }
 
 func SavePrediction(db *sql.DB, pred *Prediction) error {
+	return SavePredictions(db, []*Prediction{pred})
+}
+
+// SavePredictions stores the predictions in one transaction, either all of them are saved or none.
+func SavePredictions(db *sql.DB, predictions []*Prediction) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, pred := range predictions {
+		if err := savePrediction(tx, pred); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func savePrediction(q queryer, pred *Prediction) error {
 	if pred.MatchId == 0 {
 		return fmt.Errorf("Match ID is null")
 	}
@@ -44,35 +88,59 @@ func SavePrediction(db *sql.DB, pred *Prediction) error {
 		return fmt.Errorf("User ID is null")
 	}
 
-	row := db.QueryRow("SELECT rowid, score FROM Predictions WHERE user_id=? AND match_id=?", pred.UserId, pred.MatchId)
+	score, err := ParseScore(pred.Score)
+	if err != nil {
+		return err
+	}
+	pred.Score = score.String()
+
+	row := q.QueryRow("SELECT rowid, score FROM Predictions WHERE user_id=? AND match_id=?", pred.UserId, pred.MatchId)
 
-	var score string
+	var oldScore string
 	var id int
-	err := row.Scan(&id, &score)
+	err = row.Scan(&id, &oldScore)
 
 	switch {
 	case err == nil:
-		if score != pred.Score {
-			err = updatePrediction(db, pred)
+		if oldScore != pred.Score {
+			err = updatePrediction(q, pred)
+			if err == nil {
+				err = addHistoryEntry(q, pred, oldScore)
+			}
 		}
 	case err == sql.ErrNoRows:
-		err = createPrediction(db, pred)
+		err = createPrediction(q, pred)
+		if err == nil {
+			err = addHistoryEntry(q, pred, "")
+		}
+	}
+	if err != nil {
+		return err
+	}
 
+	// map predictions are only replaced when they are given
+	if pred.Maps != nil {
+		return saveMapPredictions(q, pred.UserId, pred.MatchId, pred.Maps)
 	}
-	return err
+	return nil
 }
 
-func loadPredictions(rows *sql.Rows) ([]*Prediction, error) {
+// loadPredictions reads predictions along with map predictions for matches in the id range.
+func loadPredictions(db *sql.DB, rows *sql.Rows, minId int64, maxId int64) ([]*Prediction, error) {
 	result := make([]*Prediction, 0)
 	for rows.Next() {
 		pred := new(Prediction)
-		rows.Scan(&pred.UserId, &pred.MatchId, &pred.Score)
+		rows.Scan(&pred.UserId, &pred.MatchId, &pred.Score, &pred.Points, &pred.Joker)
 		result = append(result, pred)
 	}
 	if rows.Err() != nil {
 		return nil, fmt.Errorf("Can't parse predictions: %s", rows.Err())
 	}
+	rows.Close()
 
+	if err := attachMapPredictions(db, result, minId, maxId); err != nil {
+		return nil, err
+	}
 	return result, nil
 }
 
@@ -81,25 +149,24 @@ func LoadPredictionsByMatches(db *sql.DB, matches []*Match) ([]*Prediction, erro
 		return make([]*Prediction, 0), nil
 	}
 
-	maxId := matches[0].Id
-	minId := maxId
-	for _, m := range matches {
-		if minId > m.Id {
-			minId = m.Id
-			continue
-		}
-
-		if maxId < m.Id {
-			maxId = m.Id
-		}
+	minId, maxId := matchesRange(matches)
+	rows, err := db.Query(SELECT_PREDICTIONS_IN_MATCHES_RANGE, minId, maxId)
+	if err != nil {
+		return nil, fmt.Errorf("Can't load predictions: %s", err.Error())
 	}
 
-	rows, err := db.Query(SELECT_PREDICTIONS_IN_MATCHES_RANGE, minId, maxId)
+	defer rows.Close()
+
+	return loadPredictions(db, rows, minId, maxId)
+}
+
+func LoadPredictionsByMatch(db *sql.DB, matchId int64) ([]*Prediction, error) {
+	rows, err := db.Query(SELECT_PREDICTIONS_BY_MATCH, matchId)
 	if err != nil {
 		return nil, fmt.Errorf("Can't load predictions: %s", err.Error())
 	}
 
 	defer rows.Close()
 
-	return loadPredictions(rows)
+	return loadPredictions(db, rows, matchId, matchId)
 }
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Score is the number of maps won by each team of a match, stored and sent as "X:Y".
+type Score struct {
+	A int
+	B int
+}
+
+// ScoreError explains why a score or map results can't be accepted.
+type ScoreError struct {
+	Text string
+}
+
+func (e *ScoreError) Error() string {
+	return e.Text
+}
+
+func scoreError(format string, args ...interface{}) error {
+	return &ScoreError{Text: fmt.Sprintf(format, args...)}
+}
+
+// MatchFormat tells how many maps can be played and if a match may end in a draw.
+type MatchFormat struct {
+	BestOf int  `json:"bestOf"`
+	Draws  bool `json:"draws"`
+}
+
+const DEFAULT_BEST_OF = 5
+
+// ParseScore parses a score in "X:Y" form. It only checks the syntax, use
+// MatchFormat.Validate to check that the score is possible.
+func ParseScore(score string) (Score, error) {
+	var s Score
+
+	parts := strings.Split(strings.TrimSpace(score), ":")
+	if len(parts) != 2 {
+		return s, scoreError("Score %q should be in X:Y format", score)
+	}
+
+	var err error
+	if s.A, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil || s.A < 0 {
+		return s, scoreError("Score %q should consist of two non-negative numbers", score)
+	}
+	if s.B, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil || s.B < 0 {
+		return s, scoreError("Score %q should consist of two non-negative numbers", score)
+	}
+
+	return s, nil
+}
+
+func (s Score) String() string {
+	return strconv.Itoa(s.A) + ":" + strconv.Itoa(s.B)
+}
+
+func (s Score) IsDraw() bool {
+	return s.A == s.B
+}
+
+// Differential is the number of maps the first team won more than the second one.
+func (s Score) Differential() int {
+	return s.A - s.B
+}
+
+// Winner returns 0 if the first team won, 1 if the second one did and -1 for a draw.
+func (s Score) Winner() int {
+	switch {
+	case s.A > s.B:
+		return 0
+	case s.A < s.B:
+		return 1
+	}
+	return -1
+}
+
+func (f MatchFormat) winsNeeded() int {
+	return f.BestOf/2 + 1
+}
+
+// Check tells whether the format is supported: an odd positive number of maps.
+func (f MatchFormat) Check() error {
+	if f.BestOf < 1 || f.BestOf%2 == 0 {
+		return scoreError("Match format best-of-%d is not supported", f.BestOf)
+	}
+	return nil
+}
+
+func (f MatchFormat) Validate(s Score) error {
+	if err := f.Check(); err != nil {
+		return err
+	}
+
+	wins := f.winsNeeded()
+	if s.IsDraw() {
+		if !f.Draws {
+			return scoreError("Score %s is a draw, the match can't end in a draw", s)
+		}
+		if s.A == 0 || s.A+s.B >= f.BestOf {
+			return scoreError("Score %s is impossible for a draw in a best-of-%d", s, f.BestOf)
+		}
+		return nil
+	}
+
+	winner, loser := s.A, s.B
+	if s.B > s.A {
+		winner, loser = s.B, s.A
+	}
+	if winner != wins {
+		return scoreError("Score %s is impossible in a best-of-%d, the winner takes exactly %d maps", s, f.BestOf, wins)
+	}
+	if loser >= wins {
+		return scoreError("Score %s is impossible in a best-of-%d", s, f.BestOf)
+	}
+	return nil
+}
+
+// ParseScore parses the score and checks it against the match format.
+func (f MatchFormat) ParseScore(score string) (Score, error) {
+	s, err := ParseScore(score)
+	if err != nil {
+		return s, err
+	}
+	return s, f.Validate(s)
+}
@@ -0,0 +1,101 @@
+package models
+
+import "testing"
+
+func TestParseScore(t *testing.T) {
+	tests := []struct {
+		score string
+		want  Score
+		ok    bool
+	}{
+		{"3:1", Score{3, 1}, true},
+		{" 0 : 3 ", Score{0, 3}, true},
+		{"2:2", Score{2, 2}, true},
+		{"3-1", Score{}, false},
+		{"3:1:0", Score{}, false},
+		{"a:1", Score{}, false},
+		{"-1:3", Score{}, false},
+		{"", Score{}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseScore(tt.score)
+		if tt.ok != (err == nil) {
+			t.Errorf("ParseScore(%q) error = %v, want ok %v", tt.score, err, tt.ok)
+			continue
+		}
+		if tt.ok && got != tt.want {
+			t.Errorf("ParseScore(%q) = %v, want %v", tt.score, got, tt.want)
+		}
+		if _, isScoreError := err.(*ScoreError); err != nil && !isScoreError {
+			t.Errorf("ParseScore(%q) error is %T, want *ScoreError", tt.score, err)
+		}
+	}
+}
+
+func TestMatchFormatCheck(t *testing.T) {
+	tests := []struct {
+		bestOf int
+		ok     bool
+	}{
+		{1, true},
+		{3, true},
+		{5, true},
+		{7, true},
+		{0, false},
+		{2, false},
+		{4, false},
+		{-1, false},
+	}
+
+	for _, tt := range tests {
+		err := MatchFormat{BestOf: tt.bestOf}.Check()
+		if tt.ok != (err == nil) {
+			t.Errorf("best-of-%d Check() = %v, want ok %v", tt.bestOf, err, tt.ok)
+		}
+	}
+}
+
+func TestMatchFormatValidate(t *testing.T) {
+	tests := []struct {
+		format MatchFormat
+		score  Score
+		ok     bool
+	}{
+		{MatchFormat{BestOf: 5}, Score{3, 0}, true},
+		{MatchFormat{BestOf: 5}, Score{2, 3}, true},
+		{MatchFormat{BestOf: 5}, Score{4, 1}, false},
+		{MatchFormat{BestOf: 5}, Score{2, 1}, false},
+		{MatchFormat{BestOf: 5}, Score{3, 3}, false},
+		{MatchFormat{BestOf: 1}, Score{1, 0}, true},
+		{MatchFormat{BestOf: 1}, Score{0, 0}, false},
+		{MatchFormat{BestOf: 3}, Score{2, 1}, true},
+		{MatchFormat{BestOf: 3}, Score{1, 1}, false},
+		{MatchFormat{BestOf: 3, Draws: true}, Score{1, 1}, true},
+		{MatchFormat{BestOf: 3, Draws: true}, Score{0, 0}, false},
+		{MatchFormat{BestOf: 5, Draws: true}, Score{2, 2}, true},
+		{MatchFormat{BestOf: 5, Draws: true}, Score{3, 3}, false},
+		{MatchFormat{BestOf: 4}, Score{3, 0}, false},
+	}
+
+	for _, tt := range tests {
+		err := tt.format.Validate(tt.score)
+		if tt.ok != (err == nil) {
+			t.Errorf("%+v Validate(%v) = %v, want ok %v", tt.format, tt.score, err, tt.ok)
+		}
+	}
+}
+
+func TestMatchFormatParseScore(t *testing.T) {
+	format := MatchFormat{BestOf: 3}
+
+	if s, err := format.ParseScore("2:0"); err != nil || s != (Score{2, 0}) {
+		t.Errorf("ParseScore(\"2:0\") = %v, %v, want 2:0", s, err)
+	}
+	if _, err := format.ParseScore("3:0"); err == nil {
+		t.Errorf("ParseScore(\"3:0\") in a best-of-3 should fail")
+	}
+	if _, err := format.ParseScore("2to0"); err == nil {
+		t.Errorf("ParseScore(\"2to0\") should fail")
+	}
+}
@@ -0,0 +1,331 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Outcome describes how close a prediction came to the actual result.
+type Outcome struct {
+	Exact        bool `json:"exact"`
+	Differential bool `json:"differential"`
+	Winner       bool `json:"winner"`
+	Maps         int  `json:"maps"`
+}
+
+// ScoringRules hold point values for a stage. Map points are a bonus for
+// every correctly predicted map winner. Rules with zero StageId are the
+// defaults of a season for stages that don't have their own, and rules with
+// zero SeasonId too are the defaults for seasons without their own defaults.
+type ScoringRules struct {
+	SeasonId     int64 `json:"seasonId"`
+	StageId      int64 `json:"stageId"`
+	Exact        int   `json:"exact"`
+	Differential int   `json:"differential"`
+	Winner       int   `json:"winner"`
+	Multiplier   int   `json:"multiplier"`
+	Map          int   `json:"map"`
+}
+
+var DefaultScoringRules = ScoringRules{
+	Exact:        3,
+	Differential: 2,
+	Winner:       1,
+	Multiplier:   1,
+	Map:          1,
+}
+
+const (
+	UPDATE_PREDICTION_POINTS   = "UPDATE Predictions SET points=? WHERE user_id=? AND match_id=?"
+	CREATE_SCORING_RULES_TABLE = "CREATE TABLE IF NOT EXISTS ScoringRules(season_id, stage_id, exact, differential, winner, multiplier, map_winner DEFAULT 1)"
+	SELECT_ALL_SCORING_RULES   = "SELECT season_id, stage_id, exact, differential, winner, multiplier, map_winner FROM ScoringRules"
+	SELECT_SCOPED_RULES        = SELECT_ALL_SCORING_RULES + " WHERE season_id=? AND stage_id=?"
+	INSERT_SCORING_RULES       = "INSERT INTO ScoringRules(season_id, stage_id, exact, differential, winner, multiplier, map_winner) VALUES(?,?,?,?,?,?,?)"
+	UPDATE_SCORING_RULES       = "UPDATE ScoringRules SET exact=?, differential=?, winner=?, multiplier=?, map_winner=? WHERE season_id=? AND stage_id=?"
+	ASSIGN_RULES_TO_SEASONS    = "UPDATE ScoringRules SET season_id=(SELECT season_id FROM Stages WHERE Stages.rowid=ScoringRules.stage_id) WHERE stage_id<>0 AND season_id=0"
+)
+
+// InitScoringRulesTable creates the scoring rules table with the global
+// defaults. Stages must be initialized first.
+func InitScoringRulesTable(db *sql.DB) error {
+	if _, err := db.Exec(CREATE_SCORING_RULES_TABLE); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "ScoringRules", "season_id", "DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "ScoringRules", "map_winner", "DEFAULT 1"); err != nil {
+		return err
+	}
+	if _, err := db.Exec(ASSIGN_RULES_TO_SEASONS); err != nil {
+		return err
+	}
+
+	_, err := LoadScopedScoringRules(db, 0, 0)
+	if err == sql.ErrNoRows {
+		r := DefaultScoringRules
+		return insertScoringRules(db, &r)
+	}
+
+	return err
+}
+
+func scanScoringRules(row scannable) (*ScoringRules, error) {
+	r := new(ScoringRules)
+	if err := row.Scan(&r.SeasonId, &r.StageId, &r.Exact, &r.Differential, &r.Winner, &r.Multiplier, &r.Map); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func insertScoringRules(db *sql.DB, r *ScoringRules) error {
+	_, err := db.Exec(INSERT_SCORING_RULES, r.SeasonId, r.StageId, r.Exact, r.Differential, r.Winner, r.Multiplier, r.Map)
+	return err
+}
+
+func LoadScoringRules(db *sql.DB) ([]*ScoringRules, error) {
+	rows, err := db.Query(SELECT_ALL_SCORING_RULES)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	result := make([]*ScoringRules, 0)
+	for rows.Next() {
+		r, err := scanScoringRules(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Can't parse scoring rules: %s", err.Error())
+		}
+		result = append(result, r)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return result, nil
+}
+
+// LoadScopedScoringRules returns the rules stored for the season and the
+// stage exactly, sql.ErrNoRows if there are none.
+func LoadScopedScoringRules(db *sql.DB, seasonId int64, stageId int64) (*ScoringRules, error) {
+	return scanScoringRules(db.QueryRow(SELECT_SCOPED_RULES, seasonId, stageId))
+}
+
+// EffectiveScoringRules returns the rules a stage is played under: its own
+// rules if there are any, the season defaults, or the global defaults
+// otherwise. Zero stageId asks for the season defaults.
+func EffectiveScoringRules(db *sql.DB, seasonId int64, stageId int64) (*ScoringRules, error) {
+	scopes := make([][2]int64, 0, 3)
+	if stageId != 0 {
+		scopes = append(scopes, [2]int64{seasonId, stageId})
+	}
+	if seasonId != 0 {
+		scopes = append(scopes, [2]int64{seasonId, 0})
+	}
+	scopes = append(scopes, [2]int64{0, 0})
+
+	for _, scope := range scopes {
+		r, err := LoadScopedScoringRules(db, scope[0], scope[1])
+		if err != sql.ErrNoRows {
+			return r, err
+		}
+	}
+
+	rules := DefaultScoringRules
+	return &rules, nil
+}
+
+func (r *ScoringRules) Validate() error {
+	if r.Exact < 0 || r.Differential < 0 || r.Winner < 0 || r.Map < 0 {
+		return fmt.Errorf("Points can't be negative")
+	}
+	if r.Multiplier < 1 {
+		return fmt.Errorf("Multiplier should be at least 1")
+	}
+	return nil
+}
+
+// SaveScoringRules stores rules for a stage or defaults. Before defaults
+// change, stages that have started and have no rules of their own get a copy
+// of the rules they were played under, so their points stay as they were. New
+// defaults only apply to stages that haven't started yet.
+func SaveScoringRules(db *sql.DB, r *ScoringRules) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	if r.StageId == 0 {
+		if err := freezeStartedStages(db, r.SeasonId); err != nil {
+			return fmt.Errorf("Can't keep rules of started stages: %s", err.Error())
+		}
+	}
+
+	_, err := LoadScopedScoringRules(db, r.SeasonId, r.StageId)
+	switch {
+	case err == sql.ErrNoRows:
+		return insertScoringRules(db, r)
+	case err != nil:
+		return err
+	}
+
+	_, err = db.Exec(UPDATE_SCORING_RULES, r.Exact, r.Differential, r.Winner, r.Multiplier, r.Map, r.SeasonId, r.StageId)
+	return err
+}
+
+// freezeStartedStages copies effective rules into running and finished stages
+// of the season, or of all seasons if seasonId is zero.
+func freezeStartedStages(db *sql.DB, seasonId int64) error {
+	var stages []*Stage
+	var err error
+	if seasonId == 0 {
+		stages, err = LoadStages(db)
+	} else {
+		stages, err = LoadStagesBySeason(db, seasonId)
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, s := range stages {
+		if !s.StartDate.Before(now) {
+			continue
+		}
+
+		_, err := LoadScopedScoringRules(db, s.SeasonId, s.Id)
+		if err == nil {
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		frozen, err := EffectiveScoringRules(db, s.SeasonId, s.Id)
+		if err != nil {
+			return err
+		}
+		frozen.SeasonId = s.SeasonId
+		frozen.StageId = s.Id
+		if err := insertScoringRules(db, frozen); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Evaluate compares a prediction with the match result. Correct differential
+// implies correct winner, and exact score implies both.
+func Evaluate(result string, prediction string) (Outcome, error) {
+	var o Outcome
+
+	r, err := ParseScore(result)
+	if err != nil {
+		return o, err
+	}
+	p, err := ParseScore(prediction)
+	if err != nil {
+		return o, err
+	}
+
+	o.Exact = r == p
+	o.Differential = r.Differential() == p.Differential()
+	o.Winner = r.Winner() == p.Winner()
+
+	return o, nil
+}
+
+// Points returns the points for the best tier the outcome reached plus the
+// map bonus, multiplied by the stage multiplier. Tiers don't add up.
+func (r *ScoringRules) Points(o Outcome) int {
+	points := r.Map * o.Maps
+	switch {
+	case o.Exact:
+		points += r.Exact
+	case o.Differential:
+		points += r.Differential
+	case o.Winner:
+		points += r.Winner
+	}
+	return points * r.Multiplier
+}
+
+// predictionPoints returns the points the prediction earns for the match, the
+// joker included. Predictions for matches that aren't scored earn nothing.
+func (r *ScoringRules) predictionPoints(m *Match, pred *Prediction) int {
+	if !m.IsScored() {
+		return 0
+	}
+
+	points := 0
+	outcome, err := Evaluate(m.Result, pred.Score)
+	if err == nil {
+		outcome.Maps = CountMapWinners(m.Maps, pred.Maps)
+		points = r.Points(outcome)
+	}
+	if pred.Joker {
+		points *= JOKER_MULTIPLIER
+	}
+	return points
+}
+
+// rulesForMatch picks the rules of the stage the match is played in.
+func rulesForMatch(db *sql.DB, m *Match) (*ScoringRules, error) {
+	var stageId int64
+	stage, err := FindStageByDate(db, m.SeasonId, m.Date)
+	switch {
+	case err == nil:
+		stageId = stage.Id
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
+
+	return EffectiveScoringRules(db, m.SeasonId, stageId)
+}
+
+// ScoreMatch recalculates and stores points of every prediction made for the match.
+func ScoreMatch(db *sql.DB, m *Match) error {
+	predictions, err := LoadPredictionsByMatch(db, m.Id)
+	if err != nil {
+		return err
+	}
+
+	rules, err := rulesForMatch(db, m)
+	if err != nil {
+		return fmt.Errorf("Can't load scoring rules: %s", err.Error())
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, pred := range predictions {
+		points := rules.predictionPoints(m, pred)
+		if _, err := tx.Exec(UPDATE_PREDICTION_POINTS, points, pred.UserId, pred.MatchId); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Can't save points: %s", err.Error())
+		}
+		pred.Points = points
+	}
+
+	return tx.Commit()
+}
+
+// RecomputePoints replays every prediction against the results of all matches.
+func RecomputePoints(db *sql.DB) error {
+	matches, err := LoadMatches(db)
+	if err != nil {
+		return fmt.Errorf("Can't load matches: %s", err.Error())
+	}
+
+	for _, m := range matches {
+		if err := ScoreMatch(db, m); err != nil {
+			return fmt.Errorf("Can't score match %d: %s", m.Id, err.Error())
+		}
+	}
+
+	return nil
+}
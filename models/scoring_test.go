@@ -0,0 +1,91 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		result     string
+		prediction string
+		want       Outcome
+	}{
+		{"3:1", "3:1", Outcome{Exact: true, Differential: true, Winner: true}},
+		{"3:1", "2:0", Outcome{Differential: true, Winner: true}},
+		{"3:1", "3:0", Outcome{Winner: true}},
+		{"3:1", "1:3", Outcome{}},
+		{"1:1", "2:2", Outcome{Differential: true, Winner: true}},
+		{"1:1", "2:1", Outcome{}},
+	}
+
+	for _, tt := range tests {
+		got, err := Evaluate(tt.result, tt.prediction)
+		if err != nil {
+			t.Errorf("Evaluate(%q, %q) error: %v", tt.result, tt.prediction, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Evaluate(%q, %q) = %+v, want %+v", tt.result, tt.prediction, got, tt.want)
+		}
+	}
+
+	if _, err := Evaluate("3:1", "three"); err == nil {
+		t.Errorf("Evaluate with a malformed prediction should fail")
+	}
+}
+
+func TestPoints(t *testing.T) {
+	rules := DefaultScoringRules
+	doubled := DefaultScoringRules
+	doubled.Multiplier = 2
+
+	tests := []struct {
+		rules   ScoringRules
+		outcome Outcome
+		want    int
+	}{
+		{rules, Outcome{Exact: true, Differential: true, Winner: true}, 3},
+		{rules, Outcome{Differential: true, Winner: true}, 2},
+		{rules, Outcome{Winner: true}, 1},
+		{rules, Outcome{}, 0},
+		{rules, Outcome{Winner: true, Maps: 2}, 3},
+		{rules, Outcome{Maps: 1}, 1},
+		{doubled, Outcome{Exact: true, Differential: true, Winner: true}, 6},
+		{doubled, Outcome{Winner: true, Maps: 1}, 4},
+	}
+
+	for _, tt := range tests {
+		if got := tt.rules.Points(tt.outcome); got != tt.want {
+			t.Errorf("%+v Points(%+v) = %d, want %d", tt.rules, tt.outcome, got, tt.want)
+		}
+	}
+}
+
+func TestPredictionPoints(t *testing.T) {
+	rules := DefaultScoringRules
+	played := time.Now().UTC().Add(-time.Hour)
+	maps := []*MatchMap{{Number: 1, Winner: "A"}, {Number: 2, Winner: "B"}}
+
+	tests := []struct {
+		name  string
+		match *Match
+		pred  *Prediction
+		want  int
+	}{
+		{"exact", &Match{Result: "3:1", Date: played}, &Prediction{Score: "3:1"}, 3},
+		{"exact with joker", &Match{Result: "3:1", Date: played}, &Prediction{Score: "3:1", Joker: true}, 3 * JOKER_MULTIPLIER},
+		{"winner with joker", &Match{Result: "3:1", Date: played}, &Prediction{Score: "3:0", Joker: true}, 1 * JOKER_MULTIPLIER},
+		{"miss with joker", &Match{Result: "3:1", Date: played}, &Prediction{Score: "0:3", Joker: true}, 0},
+		{"maps", &Match{Result: "3:1", Date: played, Maps: maps}, &Prediction{Score: "3:0", Maps: []*MapPrediction{{Number: 1, Winner: "A"}, {Number: 2, Winner: "A"}}}, 2},
+		{"maps with joker", &Match{Result: "3:1", Date: played, Maps: maps}, &Prediction{Score: "3:1", Joker: true, Maps: []*MapPrediction{{Number: 1, Winner: "A"}, {Number: 2, Winner: "B"}}}, 5 * JOKER_MULTIPLIER},
+		{"no result", &Match{Date: played}, &Prediction{Score: "3:1", Joker: true}, 0},
+		{"cancelled", &Match{Result: "3:1", Date: played, Status: STATUS_CANCELLED}, &Prediction{Score: "3:1"}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := rules.predictionPoints(tt.match, tt.pred); got != tt.want {
+			t.Errorf("%s: predictionPoints = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
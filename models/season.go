@@ -0,0 +1,230 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Season groups stages of one competition, e.g. "Overwatch League 2018".
+// Several seasons may run at the same time. Archived seasons are read-only.
+type Season struct {
+	Id          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Competition string    `json:"competition"`
+	Archived    bool      `json:"archived"`
+	Created     time.Time `json:"created"`
+}
+
+const (
+	SETTING_CURRENT_SEASON = "current_season"
+
+	CREATE_SEASONS_TABLE      = "CREATE TABLE IF NOT EXISTS Seasons(name, competition, archived, created)"
+	CREATE_SEASON_TEAMS_TABLE = "CREATE TABLE IF NOT EXISTS SeasonTeams(season_id, team_code, UNIQUE(season_id, team_code))"
+	SELECT_ALL_SEASONS        = "SELECT rowid, name, competition, archived, created FROM Seasons"
+	SELECT_SEASON_BY_ID       = SELECT_ALL_SEASONS + " WHERE rowid=?"
+	SELECT_LATEST_SEASON      = SELECT_ALL_SEASONS + " WHERE archived=0 ORDER BY rowid DESC LIMIT 1"
+	INSERT_SEASON             = "INSERT INTO Seasons(name, competition, archived, created) VALUES(?,?,?,?)"
+	UPDATE_SEASON             = "UPDATE Seasons SET name=?, competition=?, archived=? WHERE rowid=?"
+	SELECT_SEASON_TEAMS       = "SELECT team_code FROM SeasonTeams WHERE season_id=? ORDER BY team_code"
+	INSERT_SEASON_TEAM        = "INSERT INTO SeasonTeams(season_id, team_code) VALUES(?,?)"
+	DELETE_SEASON_TEAMS       = "DELETE FROM SeasonTeams WHERE season_id=?"
+	SELECT_SEASON_TEAM_EXISTS = "SELECT COUNT(*), SUM(team_code=?) FROM SeasonTeams WHERE season_id=?"
+)
+
+var ErrSeasonArchived = errors.New("Season is archived")
+
+// InitSeasonsTable creates the seasons tables. A database from the times of
+// a single season gets one season created for everything it already has.
+func InitSeasonsTable(db *sql.DB) error {
+	if _, err := db.Exec(CREATE_SEASONS_TABLE); err != nil {
+		return err
+	}
+	if _, err := db.Exec(CREATE_SEASON_TEAMS_TABLE); err != nil {
+		return err
+	}
+
+	_, err := scanSeason(db.QueryRow(SELECT_ALL_SEASONS + " LIMIT 1"))
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	return AddSeason(db, &Season{Name: "Overwatch League 2018", Competition: "Overwatch League"})
+}
+
+func scanSeason(row scannable) (*Season, error) {
+	s := new(Season)
+	var created string
+	if err := row.Scan(&s.Id, &s.Name, &s.Competition, &s.Archived, &created); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if s.Created, err = time.Parse(TIMEFORMAT, created); err != nil {
+		return nil, fmt.Errorf("Can't parse date %s: %s", created, err.Error())
+	}
+	return s, nil
+}
+
+func AddSeason(db *sql.DB, s *Season) error {
+	s.Name = strings.TrimSpace(s.Name)
+	if len(s.Name) == 0 {
+		return fmt.Errorf("Season name is empty")
+	}
+
+	s.Created = time.Now().UTC()
+	result, err := db.Exec(INSERT_SEASON, s.Name, s.Competition, s.Archived, s.Created.Format(TIMEFORMAT))
+	if err != nil {
+		return err
+	}
+
+	s.Id, _ = result.LastInsertId()
+	return nil
+}
+
+func SaveSeason(db *sql.DB, s *Season) error {
+	s.Name = strings.TrimSpace(s.Name)
+	if len(s.Name) == 0 {
+		return fmt.Errorf("Season name is empty")
+	}
+
+	res, err := db.Exec(UPDATE_SEASON, s.Name, s.Competition, s.Archived, s.Id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func LoadSeason(db *sql.DB, id int64) (*Season, error) {
+	return scanSeason(db.QueryRow(SELECT_SEASON_BY_ID, id))
+}
+
+func LoadSeasons(db *sql.DB) ([]*Season, error) {
+	rows, err := db.Query(SELECT_ALL_SEASONS)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	seasons := make([]*Season, 0)
+	for rows.Next() {
+		s, err := scanSeason(rows)
+		if err != nil {
+			return nil, err
+		}
+		seasons = append(seasons, s)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return seasons, nil
+}
+
+// GetCurrentSeason returns the season chosen by admins as the current one,
+// or the latest season that isn't archived if none was chosen.
+func GetCurrentSeason(db *sql.DB) (*Season, error) {
+	value, err := GetSetting(db, SETTING_CURRENT_SEASON, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if id, err := strconv.ParseInt(value, 10, 64); err == nil {
+		s, err := LoadSeason(db, id)
+		if err != sql.ErrNoRows {
+			return s, err
+		}
+	}
+
+	return scanSeason(db.QueryRow(SELECT_LATEST_SEASON))
+}
+
+func SetCurrentSeason(db *sql.DB, id int64) error {
+	return SetSetting(db, SETTING_CURRENT_SEASON, strconv.FormatInt(id, 10))
+}
+
+// LoadSeasonTeams returns codes of the teams taking part in the season.
+// Empty list means that any known team may play.
+func LoadSeasonTeams(db *sql.DB, seasonId int64) ([]string, error) {
+	rows, err := db.Query(SELECT_SEASON_TEAMS, seasonId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	codes := make([]string, 0)
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return codes, nil
+}
+
+// SaveSeasonTeams replaces the teams taking part in the season. Teams of
+// archived seasons can't be changed.
+func SaveSeasonTeams(db *sql.DB, seasonId int64, codes []string) error {
+	season, err := LoadSeason(db, seasonId)
+	if err != nil {
+		return err
+	}
+	if season.Archived {
+		return ErrSeasonArchived
+	}
+	if err := ValidateTeams(db, codes...); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(DELETE_SEASON_TEAMS, seasonId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, code := range codes {
+		if _, err := tx.Exec(INSERT_SEASON_TEAM, seasonId, code); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// validateSeasonTeams checks that the teams take part in the season.
+func validateSeasonTeams(db *sql.DB, seasonId int64, codes ...string) error {
+	for _, code := range codes {
+		var total int
+		var found sql.NullInt64
+		if err := db.QueryRow(SELECT_SEASON_TEAM_EXISTS, code, seasonId).Scan(&total, &found); err != nil {
+			return err
+		}
+		if total != 0 && found.Int64 == 0 {
+			return ErrUnknownTeam
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Session is a login of a user on some device. Only a hash of the token is
+// stored, the token itself is known to the client only.
+type Session struct {
+	Id        int64     `json:"id"`
+	UserId    int64     `json:"-"`
+	Token     string    `json:"-"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+	UserAgent string    `json:"userAgent"`
+	Ip        string    `json:"ip"`
+}
+
+const (
+	SESSION_TTL = time.Hour * 24 * 7
+
+	CREATE_SESSIONS_TABLE    = "CREATE TABLE IF NOT EXISTS Sessions(token_hash, user_id, created, expires, user_agent, ip)"
+	INSERT_SESSION           = "INSERT INTO Sessions(token_hash, user_id, created, expires, user_agent, ip) VALUES(?,?,?,?,?,?)"
+	SELECT_SESSIONS          = "SELECT rowid, user_id, created, expires, user_agent, ip FROM Sessions"
+	SELECT_USER_SESSIONS     = SELECT_SESSIONS + " WHERE user_id=? AND expires > ? ORDER BY created DESC"
+	SELECT_SESSION_WITH_USER = "SELECT s.rowid, s.user_id, s.created, s.expires, s.user_agent, s.ip, u.login, u.name, u.is_admin FROM Sessions s JOIN Users u ON u.rowid = s.user_id WHERE s.token_hash=? AND s.expires > ?"
+	DELETE_SESSION           = "DELETE FROM Sessions WHERE token_hash=?"
+	DELETE_USER_SESSION      = "DELETE FROM Sessions WHERE rowid=? AND user_id=?"
+	DELETE_OTHER_SESSIONS    = "DELETE FROM Sessions WHERE user_id=? AND rowid<>?"
+	DELETE_EXPIRED_SESSIONS  = "DELETE FROM Sessions WHERE expires <= ?"
+)
+
+func InitSessionsTable(db *sql.DB) error {
+	_, err := db.Exec(CREATE_SESSIONS_TABLE)
+	return err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateSession starts a new session for s.UserId and fills in its token.
+func CreateSession(db *sql.DB, s *Session) error {
+	if s.UserId == 0 {
+		return fmt.Errorf("User ID is null")
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return fmt.Errorf("Can't generate session token: %s", err.Error())
+	}
+
+	now := time.Now().UTC()
+	if _, err := db.Exec(DELETE_EXPIRED_SESSIONS, now.Format(TIMEFORMAT)); err != nil {
+		return err
+	}
+
+	s.Token = token
+	s.Created = now
+	s.Expires = now.Add(SESSION_TTL)
+
+	result, err := db.Exec(INSERT_SESSION, hashToken(token), s.UserId, s.Created.Format(TIMEFORMAT), s.Expires.Format(TIMEFORMAT), s.UserAgent, s.Ip)
+	if err != nil {
+		return err
+	}
+
+	s.Id, _ = result.LastInsertId()
+	return nil
+}
+
+func scanSession(row scannable, extra ...interface{}) (*Session, error) {
+	var created, expires string
+	s := new(Session)
+
+	dest := append([]interface{}{&s.Id, &s.UserId, &created, &expires, &s.UserAgent, &s.Ip}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if s.Created, err = time.Parse(TIMEFORMAT, created); err != nil {
+		return nil, fmt.Errorf("Can't parse date %s: %s", created, err.Error())
+	}
+	if s.Expires, err = time.Parse(TIMEFORMAT, expires); err != nil {
+		return nil, fmt.Errorf("Can't parse date %s: %s", expires, err.Error())
+	}
+	return s, nil
+}
+
+// LoadSessionUser finds an active session by its token together with its user.
+func LoadSessionUser(db *sql.DB, token string) (*User, *Session, error) {
+	row := db.QueryRow(SELECT_SESSION_WITH_USER, hashToken(token), time.Now().UTC().Format(TIMEFORMAT))
+
+	u := new(User)
+	s, err := scanSession(row, &u.Login, &u.Name, &u.IsAdmin)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil, fmt.Errorf("Session is not found or expired")
+	case err != nil:
+		return nil, nil, err
+	}
+
+	u.Id = s.UserId
+	s.Token = token
+
+	return u, s, nil
+}
+
+func LoadUserSessions(db *sql.DB, userId int64) ([]*Session, error) {
+	rows, err := db.Query(SELECT_USER_SESSIONS, userId, time.Now().UTC().Format(TIMEFORMAT))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sessions := make([]*Session, 0)
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return sessions, nil
+}
+
+func DeleteSession(db *sql.DB, token string) error {
+	_, err := db.Exec(DELETE_SESSION, hashToken(token))
+	return err
+}
+
+// DeleteUserSession revokes one of the user's sessions by its id.
+func DeleteUserSession(db *sql.DB, userId int64, id int64) error {
+	res, err := db.Exec(DELETE_USER_SESSION, id, userId)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return fmt.Errorf("No such session")
+	}
+	return nil
+}
+
+// DeleteOtherSessions revokes every session of the user except the given one.
+func DeleteOtherSessions(db *sql.DB, userId int64, keepId int64) error {
+	_, err := db.Exec(DELETE_OTHER_SESSIONS, userId, keepId)
+	return err
+}
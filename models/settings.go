@@ -0,0 +1,55 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const (
+	SETTING_REGISTRATION_MODE = "registration_mode"
+
+	REGISTRATION_OPEN   = "open"
+	REGISTRATION_INVITE = "invite"
+	REGISTRATION_CLOSED = "closed"
+
+	CREATE_SETTINGS_TABLE = "CREATE TABLE IF NOT EXISTS Settings(key PRIMARY KEY, value)"
+	SELECT_SETTING        = "SELECT value FROM Settings WHERE key=?"
+	REPLACE_SETTING       = "INSERT OR REPLACE INTO Settings(key, value) VALUES(?,?)"
+)
+
+func InitSettingsTable(db *sql.DB) error {
+	_, err := db.Exec(CREATE_SETTINGS_TABLE)
+	return err
+}
+
+// GetSetting returns the value of a setting, or the fallback if it was never set.
+func GetSetting(db *sql.DB, key string, fallback string) (string, error) {
+	var value string
+	err := db.QueryRow(SELECT_SETTING, key).Scan(&value)
+	switch {
+	case err == sql.ErrNoRows:
+		return fallback, nil
+	case err != nil:
+		return "", err
+	}
+	return value, nil
+}
+
+func SetSetting(db *sql.DB, key string, value string) error {
+	_, err := db.Exec(REPLACE_SETTING, key, value)
+	return err
+}
+
+// GetRegistrationMode tells who may register. Registration is closed unless an admin opens it.
+func GetRegistrationMode(db *sql.DB) (string, error) {
+	return GetSetting(db, SETTING_REGISTRATION_MODE, REGISTRATION_CLOSED)
+}
+
+func SetRegistrationMode(db *sql.DB, mode string) error {
+	switch mode {
+	case REGISTRATION_OPEN, REGISTRATION_INVITE, REGISTRATION_CLOSED:
+	default:
+		return fmt.Errorf("Unknown registration mode %q", mode)
+	}
+	return SetSetting(db, SETTING_REGISTRATION_MODE, mode)
+}
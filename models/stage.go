@@ -2,25 +2,63 @@ package models
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
 type Stage struct {
-	Id        int64     `json:id`
-	Name      string    `json:name`
-	StartDate time.Time `json:startDate`
-	EndDate   time.Time `json:endDate`
+	Id        int64     `json:"id"`
+	Name      string    `json:"name"`
+	StartDate time.Time `json:"startDate"`
+	EndDate   time.Time `json:"endDate"`
+	SeasonId  int64     `json:"seasonId"`
+
+	LockMode    string `json:"lockMode"`
+	LockMinutes int    `json:"lockMinutes"`
 }
 
 const (
-	CREATE_STAGES_TABLE  = "CREATE TABLE IF NOT EXISTS Stages(name, start_date, end_date)"
-	SELECT_ALL_STAGES    = "SELECT rowid, name, start_date, end_date FROM Stages"
-	SELECT_CURRENT_STAGE = SELECT_ALL_STAGES + " WHERE date('now') >= date(start_date) AND date('now') <= date(end_date)"
+	CREATE_STAGES_TABLE     = "CREATE TABLE IF NOT EXISTS Stages(name, start_date, end_date, season_id, lock_mode DEFAULT 'match', lock_minutes DEFAULT 0)"
+	SELECT_ALL_STAGES       = "SELECT rowid, name, start_date, end_date, season_id, lock_mode, lock_minutes FROM Stages"
+	SELECT_CURRENT_STAGE    = SELECT_ALL_STAGES + " WHERE season_id=? AND date('now') >= date(start_date) AND date('now') <= date(end_date)"
+	SELECT_STAGE_BY_ID      = SELECT_ALL_STAGES + " WHERE rowid=?"
+	SELECT_STAGE_BY_DATE    = SELECT_ALL_STAGES + " WHERE season_id=? AND start_date <= ? AND end_date >= ? ORDER BY start_date DESC LIMIT 1"
+	SELECT_SEASON_STAGES    = SELECT_ALL_STAGES + " WHERE season_id=? ORDER BY start_date"
+	ASSIGN_STAGES_TO_SEASON = "UPDATE Stages SET season_id=(SELECT MIN(rowid) FROM Seasons) WHERE season_id=0"
+	SELECT_OVERLAPPING      = "SELECT COUNT(*) FROM Stages WHERE season_id=? AND rowid<>? AND start_date <= ? AND end_date >= ?"
+	INSERT_STAGE            = "INSERT INTO Stages(name, start_date, end_date, season_id, lock_mode, lock_minutes) VALUES(?,?,?,?,?,?)"
+	UPDATE_STAGE            = "UPDATE Stages SET name=?, start_date=?, end_date=?, season_id=?, lock_mode=?, lock_minutes=? WHERE rowid=?"
+	DELETE_STAGE            = "DELETE FROM Stages WHERE rowid=?"
+	DELETE_STAGE_RULES      = "DELETE FROM ScoringRules WHERE stage_id=?"
+	COUNT_STAGE_MARKETS     = "SELECT COUNT(*) FROM FutureMarkets WHERE stage_id=?"
+)
+
+var (
+	ErrStageOverlap        = errors.New("Stage overlaps with another stage of the season")
+	ErrStageHasMatches     = errors.New("Stage has matches")
+	ErrStageStrandsMatches = errors.New("Stage has matches that would be left outside of it")
+	ErrStageHasMarkets     = errors.New("Stage has futures markets")
 )
 
+// InitStagesTable creates the stages table. Stages from before seasons were
+// introduced are assigned to the first season. Seasons must be initialized first.
 func InitStagesTable(db *sql.DB) error {
-	_, err := db.Exec(CREATE_STAGES_TABLE)
+	if _, err := db.Exec(CREATE_STAGES_TABLE); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "Stages", "season_id", "DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "Stages", "lock_mode", "DEFAULT '"+LOCK_MATCH+"'"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "Stages", "lock_minutes", "DEFAULT 0"); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(ASSIGN_STAGES_TO_SEASON)
 	return err
 }
 
@@ -33,7 +71,11 @@ func scanStage(row scannable) (*Stage, error) {
 	var err error
 	s := new(Stage)
 
-	if err := row.Scan(&s.Id, &s.Name, &start, &end); err != nil {
+	err = row.Scan(&s.Id, &s.Name, &start, &end, &s.SeasonId, &s.LockMode, &s.LockMinutes)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
 		return nil, fmt.Errorf("Can't extract stage information from database: %s", err.Error())
 	}
 	if s.StartDate, err = time.Parse(TIMEFORMAT, start); err != nil {
@@ -45,20 +87,27 @@ func scanStage(row scannable) (*Stage, error) {
 	return s, nil
 }
 
-func GetCurrentStage(db *sql.DB) (*Stage, error) {
-	row := db.QueryRow(SELECT_CURRENT_STAGE)
+func GetCurrentStage(db *sql.DB, seasonId int64) (*Stage, error) {
+	row := db.QueryRow(SELECT_CURRENT_STAGE, seasonId)
 
 	return scanStage(row)
 }
 
-func LoadStages(db *sql.DB) ([]*Stage, error) {
-	rows, err := db.Query(SELECT_ALL_STAGES)
-	if err != nil {
-		return nil, err
-	}
+func LoadStage(db *sql.DB, id int64) (*Stage, error) {
+	row := db.QueryRow(SELECT_STAGE_BY_ID, id)
 
-	defer rows.Close()
+	return scanStage(row)
+}
 
+// FindStageByDate returns the stage of the season the moment belongs to, sql.ErrNoRows if there is none.
+func FindStageByDate(db *sql.DB, seasonId int64, date time.Time) (*Stage, error) {
+	formatted := date.UTC().Format(TIMEFORMAT)
+	row := db.QueryRow(SELECT_STAGE_BY_DATE, seasonId, formatted, formatted)
+
+	return scanStage(row)
+}
+
+func loadStages(rows *sql.Rows) ([]*Stage, error) {
 	stages := make([]*Stage, 0)
 	for rows.Next() {
 		s, err := scanStage(rows)
@@ -74,3 +123,160 @@ func LoadStages(db *sql.DB) ([]*Stage, error) {
 
 	return stages, nil
 }
+
+func LoadStages(db *sql.DB) ([]*Stage, error) {
+	rows, err := db.Query(SELECT_ALL_STAGES)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return loadStages(rows)
+}
+
+func LoadStagesBySeason(db *sql.DB, seasonId int64) ([]*Stage, error) {
+	rows, err := db.Query(SELECT_SEASON_STAGES, seasonId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return loadStages(rows)
+}
+
+// validateStage checks the stage before it is stored: dates should form a
+// range that doesn't intersect other stages of the same season.
+func validateStage(db *sql.DB, s *Stage) error {
+	s.Name = strings.TrimSpace(s.Name)
+	if len(s.Name) == 0 {
+		return fmt.Errorf("Stage name is empty")
+	}
+	if s.StartDate.IsZero() || s.EndDate.IsZero() {
+		return fmt.Errorf("Stage dates are required")
+	}
+	if !s.StartDate.Before(s.EndDate) {
+		return fmt.Errorf("Stage should start before it ends")
+	}
+	if err := validateLockPolicy(s); err != nil {
+		return err
+	}
+
+	season, err := LoadSeason(db, s.SeasonId)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("No season with id: %d", s.SeasonId)
+	}
+	if err != nil {
+		return err
+	}
+	if season.Archived {
+		return ErrSeasonArchived
+	}
+
+	var count int
+	err = db.QueryRow(SELECT_OVERLAPPING, s.SeasonId, s.Id, s.EndDate.UTC().Format(TIMEFORMAT), s.StartDate.UTC().Format(TIMEFORMAT)).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		return ErrStageOverlap
+	}
+	return nil
+}
+
+func AddStage(db *sql.DB, s *Stage) error {
+	if err := validateStage(db, s); err != nil {
+		return err
+	}
+
+	result, err := db.Exec(INSERT_STAGE, s.Name, s.StartDate.UTC().Format(TIMEFORMAT), s.EndDate.UTC().Format(TIMEFORMAT), s.SeasonId,
+		s.LockMode, s.LockMinutes)
+	if err != nil {
+		return err
+	}
+
+	s.Id, _ = result.LastInsertId()
+	return nil
+}
+
+// SaveStage updates a stage. The season and dates of a stage can't change so
+// that any of its matches would fall outside of it.
+func SaveStage(db *sql.DB, s *Stage) error {
+	if s.Id == 0 {
+		return fmt.Errorf("Stage ID is null")
+	}
+	if err := validateStage(db, s); err != nil {
+		return err
+	}
+
+	old, err := LoadStage(db, s.Id)
+	if err != nil {
+		return err
+	}
+	matches, err := LoadMatchesByStage(db, old)
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		if m.SeasonId != s.SeasonId || m.Date.Before(s.StartDate) || m.Date.After(s.EndDate) {
+			return ErrStageStrandsMatches
+		}
+	}
+
+	res, err := db.Exec(UPDATE_STAGE, s.Name, s.StartDate.UTC().Format(TIMEFORMAT), s.EndDate.UTC().Format(TIMEFORMAT), s.SeasonId,
+		s.LockMode, s.LockMinutes, s.Id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteStage removes a stage together with its scoring rules. Stages that
+// already have matches or futures markets can't be deleted.
+func DeleteStage(db *sql.DB, id int64) error {
+	s, err := LoadStage(db, id)
+	if err != nil {
+		return err
+	}
+
+	matches, err := LoadMatchesByStage(db, s)
+	if err != nil {
+		return err
+	}
+	if len(matches) != 0 {
+		return ErrStageHasMatches
+	}
+
+	var markets int
+	if err := db.QueryRow(COUNT_STAGE_MARKETS, id).Scan(&markets); err != nil {
+		return err
+	}
+	if markets != 0 {
+		return ErrStageHasMarkets
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(DELETE_STAGE_RULES, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(DELETE_STAGE, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"sort"
+)
+
+type StandingsRow struct {
+	Rank            int    `json:"rank"`
+	Team            string `json:"team"`
+	Played          int    `json:"played"`
+	Wins            int    `json:"wins"`
+	Losses          int    `json:"losses"`
+	Draws           int    `json:"draws"`
+	MapsWon         int    `json:"mapsWon"`
+	MapsLost        int    `json:"mapsLost"`
+	MapDifferential int    `json:"mapDifferential"`
+}
+
+// record is a result of a team in a set of matches.
+type record struct {
+	wins, losses, mapDifferential int
+}
+
+// BuildStandings ranks teams playing the matches the way OWL does: by match
+// wins, then by losses, then by map differential. Teams still tied are
+// ordered by the matches played between them, first by wins and then by map
+// differential, and finally by team code so the order is stable. Cancelled
+// matches don't count, matches without a result only put teams into the table.
+func BuildStandings(matches []*Match) []*StandingsRow {
+	rowsMap := make(map[string]*StandingsRow)
+	rows := make([]*StandingsRow, 0)
+	played := make([]*Match, 0, len(matches))
+	for _, m := range matches {
+		if m.Status == STATUS_CANCELLED {
+			continue
+		}
+		for _, team := range m.Teams {
+			if _, ok := rowsMap[team]; !ok {
+				row := &StandingsRow{Team: team}
+				rowsMap[team] = row
+				rows = append(rows, row)
+			}
+		}
+		if m.IsScored() {
+			played = append(played, m)
+		}
+	}
+
+	for _, m := range played {
+		score, err := ParseScore(m.Result)
+		if err != nil {
+			continue
+		}
+		a, b := rowsMap[m.Teams[0]], rowsMap[m.Teams[1]]
+		a.Played++
+		b.Played++
+		a.MapsWon += score.A
+		a.MapsLost += score.B
+		b.MapsWon += score.B
+		b.MapsLost += score.A
+		switch score.Winner() {
+		case 0:
+			a.Wins++
+			b.Losses++
+		case 1:
+			b.Wins++
+			a.Losses++
+		default:
+			a.Draws++
+			b.Draws++
+		}
+	}
+	for _, row := range rows {
+		row.MapDifferential = row.MapsWon - row.MapsLost
+	}
+
+	tied := func(a, b *StandingsRow) bool {
+		return a.Wins == b.Wins && a.Losses == b.Losses && a.MapDifferential == b.MapDifferential
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		switch {
+		case a.Wins != b.Wins:
+			return a.Wins > b.Wins
+		case a.Losses != b.Losses:
+			return a.Losses < b.Losses
+		case a.MapDifferential != b.MapDifferential:
+			return a.MapDifferential > b.MapDifferential
+		}
+		return a.Team < b.Team
+	})
+
+	for start := 0; start < len(rows); {
+		end := start + 1
+		for end < len(rows) && tied(rows[start], rows[end]) {
+			end++
+		}
+		if end-start > 1 {
+			breakTie(rows[start:end], played)
+		}
+		start = end
+	}
+
+	for i, row := range rows {
+		row.Rank = i + 1
+	}
+
+	return rows
+}
+
+// breakTie orders tied teams by the matches they played against each other.
+func breakTie(rows []*StandingsRow, matches []*Match) {
+	group := make(map[string]*record)
+	for _, row := range rows {
+		group[row.Team] = new(record)
+	}
+
+	for _, m := range matches {
+		a, okA := group[m.Teams[0]]
+		b, okB := group[m.Teams[1]]
+		if !okA || !okB {
+			continue
+		}
+		score, err := ParseScore(m.Result)
+		if err != nil {
+			continue
+		}
+		a.mapDifferential += score.Differential()
+		b.mapDifferential -= score.Differential()
+		switch score.Winner() {
+		case 0:
+			a.wins++
+			b.losses++
+		case 1:
+			b.wins++
+			a.losses++
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := group[rows[i].Team], group[rows[j].Team]
+		switch {
+		case a.wins != b.wins:
+			return a.wins > b.wins
+		case a.mapDifferential != b.mapDifferential:
+			return a.mapDifferential > b.mapDifferential
+		}
+		return rows[i].Team < rows[j].Team
+	})
+}
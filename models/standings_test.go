@@ -0,0 +1,87 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func played(a string, b string, result string) *Match {
+	return &Match{Teams: [2]string{a, b}, Result: result, Date: time.Now().UTC().Add(-time.Hour)}
+}
+
+func TestBuildStandings(t *testing.T) {
+	cancelled := played("AAA", "CCC", "0:3")
+	cancelled.Status = STATUS_CANCELLED
+
+	tests := []struct {
+		name    string
+		matches []*Match
+		want    []string
+	}{
+		{
+			name: "wins, then losses, then map differential",
+			matches: []*Match{
+				played("AAA", "BBB", "3:0"),
+				played("AAA", "CCC", "3:1"),
+				played("BBB", "CCC", "3:2"),
+				played("DDD", "CCC", "2:2"),
+			},
+			want: []string{"AAA", "BBB", "DDD", "CCC"},
+		},
+		{
+			name: "head-to-head breaks a tie",
+			matches: []*Match{
+				played("AAA", "BBB", "2:3"),
+				played("AAA", "CCC", "3:0"),
+				played("AAA", "DDD", "3:2"),
+				played("BBB", "DDD", "3:0"),
+				played("BBB", "CCC", "2:3"),
+				played("DDD", "CCC", "3:0"),
+			},
+			want: []string{"BBB", "AAA", "DDD", "CCC"},
+		},
+		{
+			name: "team code breaks a full tie",
+			matches: []*Match{
+				played("CCC", "AAA", "3:1"),
+				played("AAA", "BBB", "3:1"),
+				played("BBB", "CCC", "3:1"),
+			},
+			want: []string{"AAA", "BBB", "CCC"},
+		},
+		{
+			name: "cancelled matches and matches without a result don't count",
+			matches: []*Match{
+				cancelled,
+				played("BBB", "AAA", ""),
+				played("CCC", "BBB", "3:0"),
+			},
+			want: []string{"CCC", "AAA", "BBB"},
+		},
+	}
+
+	for _, tt := range tests {
+		rows := BuildStandings(tt.matches)
+		if len(rows) != len(tt.want) {
+			t.Errorf("%s: got %d rows, want %d", tt.name, len(rows), len(tt.want))
+			continue
+		}
+		for i, row := range rows {
+			if row.Team != tt.want[i] || row.Rank != i+1 {
+				t.Errorf("%s: row %d is %s ranked %d, want %s", tt.name, i, row.Team, row.Rank, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestBuildStandingsRecords(t *testing.T) {
+	rows := BuildStandings([]*Match{
+		played("AAA", "BBB", "3:1"),
+		played("BBB", "AAA", "2:2"),
+	})
+
+	want := StandingsRow{Rank: 1, Team: "AAA", Played: 2, Wins: 1, Draws: 1, MapsWon: 5, MapsLost: 3, MapDifferential: 2}
+	if *rows[0] != want {
+		t.Errorf("first row = %+v, want %+v", *rows[0], want)
+	}
+}
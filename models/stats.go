@@ -0,0 +1,103 @@
+package models
+
+import (
+	"math"
+)
+
+// MatchStats aggregate predictions for a match without revealing any of them.
+// Percentages are of all valid predictions, the differential is maps won by the
+// first team minus maps won by the second one.
+type MatchStats struct {
+	MatchId             int64      `json:"matchId"`
+	Predictions         int        `json:"predictions"`
+	Teams               [2]float64 `json:"teams"`
+	Draws               float64    `json:"draws"`
+	CommonScore         string     `json:"commonScore"`
+	CommonScorePercent  float64    `json:"commonScorePercent"`
+	AverageDifferential float64    `json:"averageDifferential"`
+}
+
+func percent(part int, total int) float64 {
+	return math.Round(float64(part)*1000/float64(total)) / 10
+}
+
+// ComputeMatchStats builds stats from the predictions made for the match.
+// Ties between the most common scores are broken by the score itself, so
+// the result is stable.
+func ComputeMatchStats(matchId int64, predictions []*Prediction) *MatchStats {
+	stats := &MatchStats{MatchId: matchId}
+
+	var winners [2]int
+	var draws, differential int
+	scores := make(map[string]int)
+	for _, pred := range predictions {
+		if pred.MatchId != matchId {
+			continue
+		}
+		score, err := ParseScore(pred.Score)
+		if err != nil {
+			continue
+		}
+
+		stats.Predictions++
+		switch w := score.Winner(); w {
+		case -1:
+			draws++
+		default:
+			winners[w]++
+		}
+		differential += score.Differential()
+		scores[score.String()]++
+	}
+
+	if stats.Predictions == 0 {
+		return stats
+	}
+
+	stats.Teams[0] = percent(winners[0], stats.Predictions)
+	stats.Teams[1] = percent(winners[1], stats.Predictions)
+	stats.Draws = percent(draws, stats.Predictions)
+	stats.AverageDifferential = math.Round(float64(differential)*100/float64(stats.Predictions)) / 100
+
+	best := 0
+	for score, count := range scores {
+		if count > best || (count == best && score < stats.CommonScore) {
+			best = count
+			stats.CommonScore = score
+		}
+	}
+	stats.CommonScorePercent = percent(best, stats.Predictions)
+
+	return stats
+}
+
+// MIN_OPEN_STATS_PREDICTIONS is how many predictions a match needs before its
+// stats are shown ahead of the lock, so that single picks can't be told apart.
+const MIN_OPEN_STATS_PREDICTIONS = 5
+
+// ComputeOpenMatchStats builds stats that can be shown before predictions
+// lock. Predictions of hidden predictors are left out, and below
+// MIN_OPEN_STATS_PREDICTIONS predictions only their number is given.
+func ComputeOpenMatchStats(matchId int64, predictions []*Prediction, hidden map[int64]bool) *MatchStats {
+	visible := make([]*Prediction, 0, len(predictions))
+	for _, pred := range predictions {
+		if !hidden[pred.UserId] {
+			visible = append(visible, pred)
+		}
+	}
+
+	stats := ComputeMatchStats(matchId, visible)
+	if stats.Predictions < MIN_OPEN_STATS_PREDICTIONS {
+		return &MatchStats{MatchId: matchId, Predictions: stats.Predictions}
+	}
+	return stats
+}
+
+// StatsFor builds stats of the match that can be shown at the moment. Before
+// predictions lock they are built by ComputeOpenMatchStats.
+func StatsFor(m *Match, predictions []*Prediction, hidden map[int64]bool) *MatchStats {
+	if m.IsLocked() {
+		return ComputeMatchStats(m.Id, predictions)
+	}
+	return ComputeOpenMatchStats(m.Id, predictions, hidden)
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func predictions(matchId int64, scores ...string) []*Prediction {
+	preds := make([]*Prediction, len(scores))
+	for i, score := range scores {
+		preds[i] = &Prediction{UserId: int64(i + 1), MatchId: matchId, Score: score}
+	}
+	return preds
+}
+
+func TestComputeMatchStats(t *testing.T) {
+	tests := []struct {
+		name  string
+		preds []*Prediction
+		want  MatchStats
+	}{
+		{
+			name:  "no predictions",
+			preds: nil,
+			want:  MatchStats{MatchId: 1},
+		},
+		{
+			name:  "one team favoured",
+			preds: predictions(1, "3:1", "3:1", "3:0", "1:3"),
+			want: MatchStats{MatchId: 1, Predictions: 4, Teams: [2]float64{75, 25},
+				CommonScore: "3:1", CommonScorePercent: 50, AverageDifferential: 1.25},
+		},
+		{
+			name:  "draws and ties between common scores",
+			preds: predictions(1, "2:2", "3:1", "1:3"),
+			want: MatchStats{MatchId: 1, Predictions: 3, Teams: [2]float64{33.3, 33.3}, Draws: 33.3,
+				CommonScore: "1:3", CommonScorePercent: 33.3},
+		},
+		{
+			name:  "malformed and other matches are skipped",
+			preds: append(predictions(1, "3:0", "bad"), &Prediction{UserId: 9, MatchId: 2, Score: "0:3"}),
+			want: MatchStats{MatchId: 1, Predictions: 1, Teams: [2]float64{100, 0},
+				CommonScore: "3:0", CommonScorePercent: 100, AverageDifferential: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := ComputeMatchStats(1, tt.preds); *got != tt.want {
+			t.Errorf("%s: ComputeMatchStats = %+v, want %+v", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func TestComputeOpenMatchStats(t *testing.T) {
+	preds := predictions(1, "3:0", "3:1", "3:1", "1:3", "0:3", "3:2")
+
+	full := ComputeOpenMatchStats(1, preds, nil)
+	if full.Predictions != 6 || full.CommonScore != "3:1" {
+		t.Errorf("stats without hidden predictors = %+v, want all 6 predictions", *full)
+	}
+
+	// Hiding two predictors leaves fewer than MIN_OPEN_STATS_PREDICTIONS
+	// predictions, so only their number is shown.
+	hidden := map[int64]bool{2: true, 3: true}
+	want := MatchStats{MatchId: 1, Predictions: 4}
+	if got := ComputeOpenMatchStats(1, preds, hidden); *got != want {
+		t.Errorf("stats with hidden predictors = %+v, want %+v", *got, want)
+	}
+}
+
+func TestStatsFor(t *testing.T) {
+	preds := predictions(1, "3:0", "3:1")
+	hidden := map[int64]bool{1: true}
+
+	open := &Match{Id: 1, LockAt: time.Now().UTC().Add(time.Hour)}
+	if got := StatsFor(open, preds, hidden); got.Predictions != 1 || got.CommonScore != "" {
+		t.Errorf("stats before the lock = %+v, want only the number of visible predictions", *got)
+	}
+
+	locked := &Match{Id: 1, LockAt: time.Now().UTC().Add(-time.Hour)}
+	if got := StatsFor(locked, preds, hidden); got.Predictions != 2 || got.CommonScore != "3:0" {
+		t.Errorf("stats after the lock = %+v, want all predictions", *got)
+	}
+}
@@ -1,83 +1,285 @@
 package models
 
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
 type Team struct {
-	id      int    `json:"id"`
+	Id      int64  `json:"id"`
 	Name    string `json:"name"`
 	Code    string `json:"code"`
 	FunName string `json:"funName"`
+	Logo    string `json:"logo"`
+	Active  bool   `json:"active"`
 }
 
-var Teams = []Team{
+const (
+	CREATE_TEAMS_TABLE = "CREATE TABLE IF NOT EXISTS Teams(code UNIQUE, name, fun_name, logo, active)"
+	SELECT_ALL_TEAMS   = "SELECT rowid, code, name, fun_name, logo, active FROM Teams"
+	SELECT_TEAM_BY_ID  = SELECT_ALL_TEAMS + " WHERE rowid=?"
+	SELECT_TEAM_COUNT  = "SELECT COUNT(*) FROM Teams"
+	SELECT_CODE_EXISTS = "SELECT COUNT(*) FROM Teams WHERE code=?"
+	INSERT_TEAM        = "INSERT INTO Teams(code, name, fun_name, logo, active) VALUES(?,?,?,?,?)"
+	UPDATE_TEAM        = "UPDATE Teams SET code=?, name=?, fun_name=?, logo=?, active=? WHERE rowid=?"
+	DELETE_TEAM        = "DELETE FROM Teams WHERE rowid=?"
+	SELECT_TEAM_USAGES = "SELECT (SELECT COUNT(*) FROM Matches WHERE team_a=? OR team_b=?)" +
+		" + (SELECT COUNT(*) FROM SeasonTeams WHERE team_code=?)" +
+		" + (SELECT COUNT(*) FROM FuturePicks WHERE team=?) + (SELECT COUNT(*) FROM FutureMarkets WHERE winner=?)"
+)
+
+var (
+	ErrUnknownTeam   = errors.New("Unknown team")
+	ErrTeamCodeTaken = errors.New("Team code is taken already")
+	ErrTeamInUse     = errors.New("Team has matches, futures picks or takes part in a season, deactivate it instead")
+)
+
+// defaultTeams are the Overwatch League 2018 teams the table is seeded with.
+var defaultTeams = []Team{
 	Team{
-		id:      1,
+		Id:      1,
 		Name:    "London Spitfire",
 		Code:    "LDN",
 		FunName: "Landan Spit",
 	},
 	Team{
-		id:      2,
+		Id:      2,
 		Name:    "Boston Uprising",
 		Code:    "BOS",
 		FunName: "Boston Downfalling",
 	},
 	Team{
-		id:      3,
+		Id:      3,
 		Name:    "Seoul Dynasty",
 		Code:    "SEO",
 		FunName: "Seoul Diveasty",
 	},
 	Team{
-		id:      4,
+		Id:      4,
 		Name:    "Houston Outlaws",
 		Code:    "HOU",
 		FunName: "J LUL KE",
 	},
 	Team{
-		id:      5,
+		Id:      5,
 		Name:    "New York Excelsior",
 		Code:    "NYE",
 		FunName: "New York Dablords",
 	},
 	Team{
-		id:      6,
+		Id:      6,
 		Name:    "Los Angeles Gladiators",
 		Code:    "GLA",
 		FunName: "Los Angeles Fissure",
 	},
 	Team{
-		id:      7,
+		Id:      7,
 		Name:    "Los Angeles Valiant",
 		Code:    "VAL",
 		FunName: "Los Angeles Valiants",
 	},
 	Team{
-		id:      8,
+		Id:      8,
 		Name:    "Shanghai Dragons",
 		Code:    "SHD",
 		FunName: "Shanghai Kappa",
 	},
 	Team{
-		id:      9,
+		Id:      9,
 		Name:    "Dallas Fuel",
 		Code:    "DAL",
 		FunName: "Dallas Cocksuckers",
 	},
 	Team{
-		id:      10,
+		Id:      10,
 		Name:    "San Francisco Shock",
 		Code:    "SFS",
 		FunName: "San Francisco Cock",
 	},
 	Team{
-		id:      11,
+		Id:      11,
 		Name:    "Florida Mayhem",
 		Code:    "FLA",
 		FunName: "Phlorida Memehem",
 	},
 	Team{
-		id:      12,
+		Id:      12,
 		Name:    "Philadelphia Fusion",
 		Code:    "PHI",
 		FunName: "Philadelphia Phusion",
 	},
 }
+
+func InitTeamsTable(db *sql.DB) error {
+	if _, err := db.Exec(CREATE_TEAMS_TABLE); err != nil {
+		return err
+	}
+
+	var count int
+	if err := db.QueryRow(SELECT_TEAM_COUNT).Scan(&count); err != nil {
+		return err
+	}
+	if count != 0 {
+		return nil
+	}
+
+	for _, t := range defaultTeams {
+		t.Active = true
+		if _, err := db.Exec(INSERT_TEAM, t.Code, t.Name, t.FunName, t.Logo, t.Active); err != nil {
+			return fmt.Errorf("Can't add team %s: %s", t.Code, err.Error())
+		}
+	}
+	return nil
+}
+
+func scanTeam(row scannable) (*Team, error) {
+	t := new(Team)
+	if err := row.Scan(&t.Id, &t.Code, &t.Name, &t.FunName, &t.Logo, &t.Active); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func LoadTeams(db *sql.DB) ([]*Team, error) {
+	rows, err := db.Query(SELECT_ALL_TEAMS)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	teams := make([]*Team, 0)
+	for rows.Next() {
+		t, err := scanTeam(rows)
+		if err != nil {
+			return nil, err
+		}
+		teams = append(teams, t)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return teams, nil
+}
+
+func LoadTeam(db *sql.DB, id int64) (*Team, error) {
+	return scanTeam(db.QueryRow(SELECT_TEAM_BY_ID, id))
+}
+
+func teamCodeExists(db *sql.DB, code string) (bool, error) {
+	var count int
+	if err := db.QueryRow(SELECT_CODE_EXISTS, code).Scan(&count); err != nil {
+		return false, err
+	}
+	return count != 0, nil
+}
+
+// ValidateTeams checks that every team code belongs to a known team.
+func ValidateTeams(db *sql.DB, codes ...string) error {
+	for _, code := range codes {
+		exists, err := teamCodeExists(db, code)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrUnknownTeam
+		}
+	}
+	return nil
+}
+
+func (t *Team) normalize() error {
+	t.Code = strings.ToUpper(strings.TrimSpace(t.Code))
+	t.Name = strings.TrimSpace(t.Name)
+	if len(t.Code) == 0 || len(t.Name) == 0 {
+		return fmt.Errorf("Team code and name are required")
+	}
+	return nil
+}
+
+func AddTeam(db *sql.DB, t *Team) error {
+	if err := t.normalize(); err != nil {
+		return err
+	}
+
+	exists, err := teamCodeExists(db, t.Code)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrTeamCodeTaken
+	}
+
+	result, err := db.Exec(INSERT_TEAM, t.Code, t.Name, t.FunName, t.Logo, t.Active)
+	if err != nil {
+		return err
+	}
+
+	t.Id, _ = result.LastInsertId()
+	return nil
+}
+
+// SaveTeam updates a team. The code of a team can't be changed while matches,
+// seasons or futures refer to it.
+func SaveTeam(db *sql.DB, t *Team) error {
+	if err := t.normalize(); err != nil {
+		return err
+	}
+
+	old, err := LoadTeam(db, t.Id)
+	if err != nil {
+		return err
+	}
+
+	if old.Code != t.Code {
+		exists, err := teamCodeExists(db, t.Code)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrTeamCodeTaken
+		}
+
+		used, err := teamIsUsed(db, old.Code)
+		if err != nil {
+			return err
+		}
+		if used {
+			return ErrTeamInUse
+		}
+	}
+
+	_, err = db.Exec(UPDATE_TEAM, t.Code, t.Name, t.FunName, t.Logo, t.Active, t.Id)
+	return err
+}
+
+func teamIsUsed(db *sql.DB, code string) (bool, error) {
+	var count int
+	if err := db.QueryRow(SELECT_TEAM_USAGES, code, code, code, code, code).Scan(&count); err != nil {
+		return false, err
+	}
+	return count != 0, nil
+}
+
+// DeleteTeam removes a team that has no matches or futures picks and takes
+// part in no season. Other teams can only be deactivated.
+func DeleteTeam(db *sql.DB, id int64) error {
+	t, err := LoadTeam(db, id)
+	if err != nil {
+		return err
+	}
+
+	used, err := teamIsUsed(db, t.Code)
+	if err != nil {
+		return err
+	}
+	if used {
+		return ErrTeamInUse
+	}
+
+	_, err = db.Exec(DELETE_TEAM, id)
+	return err
+}
@@ -2,11 +2,15 @@ package models
 
 import (
 	"crypto/md5"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
@@ -17,9 +21,18 @@ type User struct {
 }
 
 const (
-	SELECT_ALL = "SELECT rowid, login, name, is_admin FROM Users"
+	PASSWORD_ALGO_MD5    = "md5"
+	PASSWORD_ALGO_BCRYPT = "bcrypt"
+
+	SELECT_ALL              = "SELECT rowid, login, name, is_admin FROM Users"
+	SELECT_USER_CREDENTIALS = "SELECT rowid, login, name, is_admin, password, password_algo FROM Users WHERE login=?"
+	UPDATE_USER_PASSWORD    = "UPDATE Users SET password=?, password_algo=? WHERE rowid=?"
+	UPDATE_USER_NAME        = "UPDATE Users SET name=? WHERE rowid=?"
+	SELECT_LOGIN_EXISTS     = "SELECT COUNT(*) FROM Users WHERE login=?"
 )
 
+var ErrLoginTaken = errors.New("Login is taken already")
+
 var cachedUsers []*User
 var usersMx sync.Mutex
 
@@ -29,16 +42,51 @@ func GetMD5Hash(text string) string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
+// hashPassword returns a salted bcrypt hash of the password.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func checkPassword(hash string, algo string, password string) bool {
+	switch algo {
+	case PASSWORD_ALGO_BCRYPT:
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case PASSWORD_ALGO_MD5, "":
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(GetMD5Hash(password))) == 1
+	}
+	return false
+}
+
 func InitUsersTable(db *sql.DB) error {
-	_, err := db.Exec("CREATE TABLE IF NOT EXISTS Users(login, name, password, is_admin)")
-	return err
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS Users(login, name, password, is_admin, password_algo)")
+	if err != nil {
+		return err
+	}
+
+	// users created before bcrypt was introduced have MD5 hashes
+	if err := addColumnIfMissing(db, "Users", "password_algo", "DEFAULT '"+PASSWORD_ALGO_MD5+"'"); err != nil {
+		return err
+	}
+
+	// Databases filled in by hand may already have duplicate logins, the index
+	// can't be built for them. AddUser checks uniqueness anyway.
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS UsersLogin ON Users(login)")
+	return nil
 }
 
-func LoadUser(db *sql.DB, login string, password string) (*User, error) {
-	row := db.QueryRow("SELECT rowid, login, name, is_admin FROM Users WHERE login=? AND password=?", strings.ToLower(login), password)
+// CheckCredentials verifies the password of a user. Passwords still stored as
+// MD5 hashes are rehashed with bcrypt once they are proven correct.
+func CheckCredentials(db *sql.DB, login string, plain string) (*User, error) {
+	row := db.QueryRow(SELECT_USER_CREDENTIALS, strings.ToLower(login))
 
 	u := new(User)
-	err := row.Scan(&u.Id, &u.Login, &u.Name, &u.IsAdmin)
+	var password string
+	var algo sql.NullString
+	err := row.Scan(&u.Id, &u.Login, &u.Name, &u.IsAdmin, &password, &algo)
 
 	switch {
 	case err == sql.ErrNoRows:
@@ -47,34 +95,94 @@ func LoadUser(db *sql.DB, login string, password string) (*User, error) {
 		return nil, err
 	}
 
+	if !checkPassword(password, algo.String, plain) {
+		return nil, fmt.Errorf("Login or password are incorrent")
+	}
+
+	if algo.String != PASSWORD_ALGO_BCRYPT {
+		hash, err := hashPassword(plain)
+		if err != nil {
+			return nil, fmt.Errorf("Can't hash password: %s", err.Error())
+		}
+		if _, err := db.Exec(UPDATE_USER_PASSWORD, hash, PASSWORD_ALGO_BCRYPT, u.Id); err != nil {
+			return nil, fmt.Errorf("Can't upgrade password hash: %s", err.Error())
+		}
+	}
+
 	return u, nil
 }
 
-func CheckCredentials(db *sql.DB, login string, password string) (*User, error) {
-	return LoadUser(db, login, GetMD5Hash(password))
+func loginExists(db *sql.DB, login string) (bool, error) {
+	var count int
+	if err := db.QueryRow(SELECT_LOGIN_EXISTS, strings.ToLower(login)).Scan(&count); err != nil {
+		return false, err
+	}
+	return count != 0, nil
 }
 
-func AddUser(db *sql.DB, login string, name string, password string, isAdmin bool) error {
-	statement, err := db.Prepare("INSERT INTO Users(login, name, password, is_admin) VALUES(?,?,?,?)")
+// AddUser stores a new user and fills in its id. Logins are case insensitive
+// and have to be unique.
+func AddUser(db *sql.DB, u *User, password string) error {
+	if len(u.Login) == 0 {
+		return fmt.Errorf("Login is empty")
+	}
+
+	exists, err := loginExists(db, u.Login)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrLoginTaken
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	statement, err := db.Prepare("INSERT INTO Users(login, name, password, is_admin, password_algo) VALUES(?,?,?,?,?)")
 	if err != nil {
 		return err
 	}
 
 	defer statement.Close()
 
-	_, err = statement.Exec(strings.ToLower(login), name, GetMD5Hash(password), isAdmin)
-	if err == nil {
-		invalidateUsersCache()
+	u.Login = strings.ToLower(u.Login)
+	result, err := statement.Exec(u.Login, u.Name, hash, u.IsAdmin, PASSWORD_ALGO_BCRYPT)
+	if err != nil {
+		return err
+	}
+
+	u.Id, _ = result.LastInsertId()
+	invalidateUsersCache()
+
+	return nil
+}
+
+func SaveUserName(db *sql.DB, id int64, name string) error {
+	if len(name) == 0 {
+		return fmt.Errorf("Name is empty")
 	}
 
+	if _, err := db.Exec(UPDATE_USER_NAME, name, id); err != nil {
+		return err
+	}
+
+	invalidateUsersCache()
+	return nil
+}
+
+func SaveUserPassword(db *sql.DB, id int64, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(UPDATE_USER_PASSWORD, hash, PASSWORD_ALGO_BCRYPT, id)
 	return err
 }
 
 func LoadUsers(db *sql.DB) ([]*User, error) {
-	users := cachedUsers
-	if users != nil {
-		return users, nil
-	}
 	usersMx.Lock()
 	defer usersMx.Unlock()
 	if cachedUsers != nil {
@@ -87,7 +195,7 @@ func LoadUsers(db *sql.DB) ([]*User, error) {
 
 	defer rows.Close()
 
-	users = make([]*User, 0)
+	users := make([]*User, 0)
 
 	for rows.Next() {
 		var id int64
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type StagePoints struct {
+	StageId int64  `json:"stageId"`
+	Name    string `json:"name"`
+	Points  int    `json:"points"`
+}
+
+// UserStats describe how well a user predicts. Rates are percentages of
+// predictions for finished matches. FavouriteTeam is the team the user backs
+// most often, MostMispredictedTeam is the one the user most often backs in
+// matches it doesn't win. LongestPredictionStreak counts predictions for
+// finished matches in a row with the winner guessed right. Matches the user
+// hasn't predicted don't break it, predictions that can't be read do.
+type UserStats struct {
+	UserId                  int64          `json:"userId"`
+	Predictions             int            `json:"predictions"`
+	Finished                int            `json:"finished"`
+	Exact                   int            `json:"exact"`
+	ExactRate               float64        `json:"exactRate"`
+	Winners                 int            `json:"winners"`
+	WinnerAccuracy          float64        `json:"winnerAccuracy"`
+	FavouriteTeam           string         `json:"favouriteTeam"`
+	MostMispredictedTeam    string         `json:"mostMispredictedTeam"`
+	LongestPredictionStreak int            `json:"longestPredictionStreak"`
+	Stages                  []*StagePoints `json:"stages"`
+}
+
+const SELECT_USER_PREDICTED_MATCHES = "SELECT Matches.rowid, team_a, team_b, date, result, season_id, status, original_date, predictions_locked, best_of, draws," +
+	" score, points FROM Predictions JOIN Matches ON Matches.rowid=Predictions.match_id WHERE user_id=? ORDER BY date ASC, Matches.rowid ASC"
+
+// withExtra scans a row that has more columns after the ones dest is for.
+type withExtra struct {
+	row   scannable
+	extra []interface{}
+}
+
+func (s withExtra) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+// mostCounted returns the key with the highest count, the smallest one of equal ones.
+func mostCounted(counts map[string]int) string {
+	best, result := 0, ""
+	for key, count := range counts {
+		if count > best || (count == best && key < result) {
+			best, result = count, key
+		}
+	}
+	return result
+}
+
+// LoadUserStats computes stats of the user from the predictions the user has
+// made. Unless all is set, only predictions that are revealed to everyone count.
+func LoadUserStats(db *sql.DB, userId int64, all bool) (*UserStats, error) {
+	rows, err := db.Query(SELECT_USER_PREDICTED_MATCHES, userId)
+	if err != nil {
+		return nil, fmt.Errorf("Can't load predictions: %s", err.Error())
+	}
+
+	defer rows.Close()
+
+	matches := make([]*Match, 0)
+	predictions := make([]*Prediction, 0)
+	for rows.Next() {
+		pred := &Prediction{UserId: userId}
+		m, err := scanMatch(withExtra{rows, []interface{}{&pred.Score, &pred.Points}})
+		if err != nil {
+			return nil, fmt.Errorf("Can't parse predictions: %s", err.Error())
+		}
+		pred.MatchId = m.Id
+		matches = append(matches, m)
+		predictions = append(predictions, pred)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	rows.Close()
+
+	if err := attachLocks(db, matches); err != nil {
+		return nil, err
+	}
+	stages, err := LoadStages(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return computeUserStats(userId, matches, predictions, stages, all), nil
+}
+
+// computeUserStats builds stats from the matches the user has predicted, in
+// the order they are played, and the predictions made for them.
+func computeUserStats(userId int64, matches []*Match, predictions []*Prediction, stages []*Stage, all bool) *UserStats {
+	stats := &UserStats{UserId: userId, Stages: make([]*StagePoints, 0)}
+	stagePoints := make(map[int64]*StagePoints)
+	backed := make(map[string]int)
+	mispredicted := make(map[string]int)
+	streak := 0
+	for i, m := range matches {
+		pred := predictions[i]
+		if !all && !m.IsLocked() {
+			continue
+		}
+		finished := m.IsScored()
+		score, err := ParseScore(pred.Score)
+		if err != nil {
+			if finished {
+				streak = 0
+			}
+			continue
+		}
+
+		stats.Predictions++
+		backedTeam := ""
+		if w := score.Winner(); w != -1 {
+			backedTeam = m.Teams[w]
+			backed[backedTeam]++
+		}
+
+		if s := stageOf(stages, m); s != nil {
+			sp, ok := stagePoints[s.Id]
+			if !ok {
+				sp = &StagePoints{StageId: s.Id, Name: s.Name}
+				stagePoints[s.Id] = sp
+				stats.Stages = append(stats.Stages, sp)
+			}
+			sp.Points += pred.Points
+		}
+
+		if !finished {
+			continue
+		}
+		outcome, err := Evaluate(m.Result, pred.Score)
+		if err != nil {
+			streak = 0
+			continue
+		}
+
+		stats.Finished++
+		if outcome.Exact {
+			stats.Exact++
+		}
+		if outcome.Winner {
+			stats.Winners++
+			streak++
+			if streak > stats.LongestPredictionStreak {
+				stats.LongestPredictionStreak = streak
+			}
+		} else {
+			streak = 0
+			if len(backedTeam) != 0 {
+				mispredicted[backedTeam]++
+			}
+		}
+	}
+
+	if stats.Finished != 0 {
+		stats.ExactRate = percent(stats.Exact, stats.Finished)
+		stats.WinnerAccuracy = percent(stats.Winners, stats.Finished)
+	}
+	stats.FavouriteTeam = mostCounted(backed)
+	stats.MostMispredictedTeam = mostCounted(mispredicted)
+
+	return stats
+}
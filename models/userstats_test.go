@@ -0,0 +1,117 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeUserStats(t *testing.T) {
+	past := time.Now().UTC().Add(-time.Hour)
+	future := time.Now().UTC().Add(time.Hour)
+
+	type pick struct {
+		teams  [2]string
+		result string
+		score  string
+		status string
+		open   bool
+	}
+	tests := []struct {
+		name      string
+		picks     []pick
+		all       bool
+		winners   int
+		exact     int
+		streak    int
+		favourite string
+		missed    string
+	}{
+		{
+			name: "streak of guessed winners",
+			picks: []pick{
+				{teams: [2]string{"AAA", "BBB"}, result: "3:1", score: "3:1"},
+				{teams: [2]string{"AAA", "CCC"}, result: "3:0", score: "3:2"},
+				{teams: [2]string{"BBB", "CCC"}, result: "0:3", score: "3:0"},
+				{teams: [2]string{"AAA", "BBB"}, result: "3:2", score: "3:0"},
+			},
+			winners:   3,
+			exact:     1,
+			streak:    2,
+			favourite: "AAA",
+			missed:    "BBB",
+		},
+		{
+			name: "unreadable predictions break the streak",
+			picks: []pick{
+				{teams: [2]string{"AAA", "BBB"}, result: "3:1", score: "3:1"},
+				{teams: [2]string{"AAA", "CCC"}, result: "3:0", score: "bad"},
+				{teams: [2]string{"BBB", "CCC"}, result: "0:3", score: "0:3"},
+			},
+			winners:   2,
+			exact:     2,
+			streak:    1,
+			favourite: "AAA",
+		},
+		{
+			name: "unfinished and cancelled matches neither count nor break the streak",
+			picks: []pick{
+				{teams: [2]string{"AAA", "BBB"}, result: "3:1", score: "3:1"},
+				{teams: [2]string{"AAA", "CCC"}, score: "0:3"},
+				{teams: [2]string{"AAA", "CCC"}, result: "0:3", score: "3:0", status: STATUS_CANCELLED},
+				{teams: [2]string{"BBB", "CCC"}, result: "0:3", score: "1:3"},
+			},
+			winners:   2,
+			exact:     1,
+			streak:    2,
+			favourite: "AAA",
+		},
+		{
+			name: "predictions that aren't revealed are left out",
+			picks: []pick{
+				{teams: [2]string{"AAA", "BBB"}, result: "3:1", score: "3:1"},
+				{teams: [2]string{"BBB", "CCC"}, score: "0:3", open: true},
+				{teams: [2]string{"BBB", "CCC"}, score: "0:3", open: true},
+			},
+			winners:   1,
+			exact:     1,
+			streak:    1,
+			favourite: "AAA",
+		},
+		{
+			name: "all predictions count for the user",
+			picks: []pick{
+				{teams: [2]string{"AAA", "BBB"}, result: "3:1", score: "3:1"},
+				{teams: [2]string{"BBB", "CCC"}, score: "0:3", open: true},
+				{teams: [2]string{"BBB", "CCC"}, score: "0:3", open: true},
+			},
+			all:       true,
+			winners:   1,
+			exact:     1,
+			streak:    1,
+			favourite: "CCC",
+		},
+	}
+
+	for _, tt := range tests {
+		matches := make([]*Match, 0, len(tt.picks))
+		preds := make([]*Prediction, 0, len(tt.picks))
+		for i, p := range tt.picks {
+			m := &Match{Id: int64(i + 1), Teams: p.teams, Result: p.result, Status: p.status, Date: past}
+			if p.open {
+				m.Date, m.LockAt = future, future
+			}
+			matches = append(matches, m)
+			preds = append(preds, &Prediction{UserId: 1, MatchId: m.Id, Score: p.score})
+		}
+
+		stats := computeUserStats(1, matches, preds, nil, tt.all)
+		if stats.Winners != tt.winners || stats.Exact != tt.exact || stats.LongestPredictionStreak != tt.streak {
+			t.Errorf("%s: winners %d, exact %d, streak %d, want %d, %d, %d", tt.name,
+				stats.Winners, stats.Exact, stats.LongestPredictionStreak, tt.winners, tt.exact, tt.streak)
+		}
+		if stats.FavouriteTeam != tt.favourite || stats.MostMispredictedTeam != tt.missed {
+			t.Errorf("%s: favourite %q, mispredicted %q, want %q, %q", tt.name,
+				stats.FavouriteTeam, stats.MostMispredictedTeam, tt.favourite, tt.missed)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aelnor/vangothrone/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+type batchItemResult struct {
+	MatchId int64  `json:"matchId"`
+	Status  string `json:"status"`
+	Text    string `json:"text,omitempty"`
+}
+
+// PutPredictionsBatch saves predictions for several matches at once. Every
+// item is checked on its own and rejected ones don't stop the rest, which are
+// saved in one transaction. Jokers are played through PUT /predictions.
+func (h *HttpHandlers) PutPredictionsBatch(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user := currentUser(r)
+
+	var jsonPredictions []struct {
+		MatchId int64                   `json:"matchId"`
+		Score   string                  `json:"score"`
+		Maps    []*models.MapPrediction `json:"maps"`
+	}
+
+	if err := processBody(w, r, &jsonPredictions); err != nil {
+		log.Printf("Can't process predictions: %v", err)
+		return
+	}
+
+	results := make([]*batchItemResult, len(jsonPredictions))
+	predictions := make([]*models.Prediction, 0, len(jsonPredictions))
+	accepted := make([]*batchItemResult, 0, len(jsonPredictions))
+	seen := make(map[int64]bool)
+	for i, item := range jsonPredictions {
+		result := &batchItemResult{MatchId: item.MatchId, Status: "Fail"}
+		results[i] = result
+
+		if seen[item.MatchId] {
+			result.Text = "Match is predicted twice"
+			continue
+		}
+		seen[item.MatchId] = true
+
+		match, err := models.LoadMatch(h.Env.DB, item.MatchId)
+		if err == sql.ErrNoRows {
+			result.Text = "Match is not found"
+			continue
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			log.Printf("Can't load match: %v", err)
+			return
+		}
+
+		score, err := checkPrediction(match, item.Score, item.Maps)
+		if err != nil {
+			result.Text = err.Error()
+			continue
+		}
+
+		predictions = append(predictions, &models.Prediction{
+			UserId:  user.Id,
+			MatchId: item.MatchId,
+			Score:   score.String(),
+			Maps:    item.Maps,
+			Ip:      clientIp(r),
+		})
+		accepted = append(accepted, result)
+	}
+
+	if err := models.SavePredictions(h.Env.DB, predictions); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		log.Printf("Can't save predictions: %v", err)
+		return
+	}
+	for _, result := range accepted {
+		result.Status = "OK"
+	}
+
+	cached.InvalidatePredictions()
+	respondWithJson(w, r, results)
+	log.Printf("Saved %d of %d predictions of user %s", len(predictions), len(results), user.Login)
+}
+
+// GetPredictionsHistory shows how predictions for a match changed. Until
+// predictions lock users see only their own changes, admins see everything.
+// Addresses are shown to admins and to the owners of predictions only.
+func (h *HttpHandlers) GetPredictionsHistory(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user := currentUser(r)
+
+	param := r.URL.Query().Get("match")
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Bad match id"}, http.StatusBadRequest)
+		log.Printf("Bad match id: %s", param)
+		return
+	}
+
+	match, err := models.LoadMatch(h.Env.DB, id)
+	if err == sql.ErrNoRows {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Match is not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Print("Can't load match: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load match"}, http.StatusInternalServerError)
+		return
+	}
+
+	entries, err := models.LoadPredictionHistory(h.Env.DB, id)
+	if err != nil {
+		log.Print("Can't load prediction history: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load prediction history"}, http.StatusInternalServerError)
+		return
+	}
+
+	visible := make([]*models.HistoryEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.UserId == user.Id || user.IsAdmin {
+			visible = append(visible, e)
+			continue
+		}
+		if !match.IsLocked() {
+			continue
+		}
+		entry := *e
+		entry.Ip = ""
+		visible = append(visible, &entry)
+	}
+
+	if err := respondWithJson(w, r, visible); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/aelnor/vangothrone/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (h *HttpHandlers) GetScoringRules(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	rules, err := models.LoadScoringRules(h.Env.DB)
+	if err != nil {
+		log.Print("Can't load scoring rules: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load scoring rules"}, http.StatusInternalServerError)
+		return
+	}
+
+	if err := respondWithJson(w, r, rules); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
+
+func (h *HttpHandlers) PutScoringRules(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var rules models.ScoringRules
+	if err := processBody(w, r, &rules); err != nil {
+		log.Printf("Can't process scoring rules: %v", err)
+		return
+	}
+
+	if err := rules.Validate(); err != nil {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	if rules.StageId != 0 {
+		stage, err := models.LoadStage(h.Env.DB, rules.StageId)
+		if err == sql.ErrNoRows {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Stage is not found"}, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Print("Can't load stage: ", err)
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load stage"}, http.StatusInternalServerError)
+			return
+		}
+		rules.SeasonId = stage.SeasonId
+	} else if rules.SeasonId != 0 {
+		_, err := models.LoadSeason(h.Env.DB, rules.SeasonId)
+		if err == sql.ErrNoRows {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Season is not found"}, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Print("Can't load season: ", err)
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load season"}, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := models.SaveScoringRules(h.Env.DB, &rules); err != nil {
+		log.Printf("Can't save scoring rules: %v", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't save scoring rules"}, http.StatusInternalServerError)
+		return
+	}
+
+	// some matches may be rescored already, so the cache goes either way
+	err := models.RecomputePoints(h.Env.DB)
+	cached.InvalidatePredictions()
+	if err != nil {
+		log.Printf("Can't recompute points: %v", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Scoring rules are saved, but points can't be recomputed"}, http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJson(w, r, &requestResult{Status: "OK"})
+	log.Printf("Scoring rules saved: %+v", rules)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aelnor/vangothrone/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+// requestedSeason resolves the "season" query parameter, falling back to the
+// current season when it is absent. On failure the response is already sent.
+func requestedSeason(w http.ResponseWriter, r *http.Request, db *sql.DB) (*models.Season, error) {
+	param := r.URL.Query().Get("season")
+
+	var season *models.Season
+	var err error
+	if len(param) == 0 {
+		season, err = models.GetCurrentSeason(db)
+	} else {
+		id, parseErr := strconv.ParseInt(param, 10, 64)
+		if parseErr != nil {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Bad season id"}, http.StatusBadRequest)
+			return nil, fmt.Errorf("Bad season id: %s", param)
+		}
+		season, err = models.LoadSeason(db, id)
+	}
+
+	if err == sql.ErrNoRows {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Season is not found"}, http.StatusNotFound)
+		return nil, fmt.Errorf("No season %q", param)
+	}
+	if err != nil {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load season"}, http.StatusInternalServerError)
+		return nil, fmt.Errorf("Can't load season: %v", err)
+	}
+
+	return season, nil
+}
+
+func seasonId(w http.ResponseWriter, p httprouter.Params) (int64, bool) {
+	paramId := p.ByName("id")
+	id, err := strconv.ParseInt(paramId, 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		log.Printf("Bad season id: %s", paramId)
+		return 0, false
+	}
+	return id, true
+}
+
+func (h *HttpHandlers) GetSeasons(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	seasons, err := models.LoadSeasons(h.Env.DB)
+	if err != nil {
+		log.Print("Can't load seasons: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load seasons"}, http.StatusInternalServerError)
+		return
+	}
+
+	current, err := models.GetCurrentSeason(h.Env.DB)
+	if err != nil && err != sql.ErrNoRows {
+		log.Print("Can't load current season: ", err)
+	}
+
+	type seasonWithCurrent struct {
+		*models.Season
+		Current bool `json:"current"`
+	}
+
+	result := make([]*seasonWithCurrent, len(seasons))
+	for i, s := range seasons {
+		result[i] = &seasonWithCurrent{Season: s, Current: current != nil && current.Id == s.Id}
+	}
+
+	if err := respondWithJson(w, r, result); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
+
+type jsonSeason struct {
+	Name        string `json:"name"`
+	Competition string `json:"competition"`
+	Archived    bool   `json:"archived"`
+	Current     bool   `json:"current"`
+}
+
+func (h *HttpHandlers) PostSeasons(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var season jsonSeason
+	if err := processBody(w, r, &season); err != nil {
+		log.Printf("Can't process season adding: %v", err)
+		return
+	}
+
+	s := &models.Season{Name: season.Name, Competition: season.Competition, Archived: season.Archived}
+	if err := models.AddSeason(h.Env.DB, s); err != nil {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	if season.Current {
+		if err := models.SetCurrentSeason(h.Env.DB, s.Id); err != nil {
+			log.Printf("Can't set current season: %v", err)
+		}
+		cached.InvalidateMatches()
+	}
+
+	respondWithJsonAndStatus(w, r, &requestResult{Status: "OK", Id: s.Id}, http.StatusCreated)
+	log.Printf("Season added: %+v", s)
+}
+
+func (h *HttpHandlers) PutSeason(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, ok := seasonId(w, p)
+	if !ok {
+		return
+	}
+
+	var season jsonSeason
+	if err := processBody(w, r, &season); err != nil {
+		log.Printf("Can't process season editing: %v", err)
+		return
+	}
+
+	s := &models.Season{Id: id, Name: season.Name, Competition: season.Competition, Archived: season.Archived}
+	err := models.SaveSeason(h.Env.DB, s)
+	if err == sql.ErrNoRows {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Season is not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	if season.Current {
+		if err := models.SetCurrentSeason(h.Env.DB, s.Id); err != nil {
+			log.Printf("Can't set current season: %v", err)
+		}
+	}
+	cached.InvalidateMatches()
+
+	respondWithJson(w, r, &requestResult{Status: "OK", Id: s.Id})
+	log.Printf("Season saved: %+v", s)
+}
+
+func (h *HttpHandlers) PutSeasonTeams(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, ok := seasonId(w, p)
+	if !ok {
+		return
+	}
+
+	var codes []string
+	if err := processBody(w, r, &codes); err != nil {
+		log.Printf("Can't process season teams: %v", err)
+		return
+	}
+
+	if _, err := models.LoadSeason(h.Env.DB, id); err != nil {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Season is not found"}, http.StatusNotFound)
+		return
+	}
+
+	err := models.SaveSeasonTeams(h.Env.DB, id, codes)
+	if err == models.ErrUnknownTeam || err == models.ErrSeasonArchived {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Can't save season teams: %v", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't save season teams"}, http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJson(w, r, &requestResult{Status: "OK", Id: id})
+	log.Printf("Teams of season %d saved: %v", id, codes)
+}
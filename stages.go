@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aelnor/vangothrone/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+func respondWithStageError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case sql.ErrNoRows:
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Stage is not found"}, http.StatusNotFound)
+	case models.ErrStageOverlap, models.ErrStageHasMatches, models.ErrStageHasMarkets, models.ErrStageStrandsMatches:
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusConflict)
+	default:
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusBadRequest)
+	}
+}
+
+func stageId(w http.ResponseWriter, p httprouter.Params) (int64, bool) {
+	paramId := p.ByName("id")
+	id, err := strconv.ParseInt(paramId, 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		log.Printf("Bad stage id: %s", paramId)
+		return 0, false
+	}
+	return id, true
+}
+
+// GetStage serves both /stages/current and /stages/:id, the router can't
+// have a static segment next to a parameter.
+func (h *HttpHandlers) GetStage(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if p.ByName("id") == "current" {
+		h.GetCurrentStage(w, r, p)
+		return
+	}
+
+	id, ok := stageId(w, p)
+	if !ok {
+		return
+	}
+
+	stage, err := models.LoadStage(h.Env.DB, id)
+	if err == sql.ErrNoRows {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Stage is not found"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Print("Can't load stage: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load stage"}, http.StatusInternalServerError)
+		return
+	}
+
+	if err := respondWithJson(w, r, stage); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
+
+func (h *HttpHandlers) GetCurrentStage(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	season, err := requestedSeason(w, r, h.Env.DB)
+	if err != nil {
+		log.Print("Can't scope current stage: ", err)
+		return
+	}
+
+	stage, err := models.GetCurrentStage(h.Env.DB, season.Id)
+	if err == sql.ErrNoRows {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "No stage is running now"}, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Print("Can't load current stage: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load stage"}, http.StatusInternalServerError)
+		return
+	}
+
+	if err := respondWithJson(w, r, stage); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
+
+func (h *HttpHandlers) PostStages(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var stage models.Stage
+	if err := processBody(w, r, &stage); err != nil {
+		log.Printf("Can't process stage adding: %v", err)
+		return
+	}
+
+	if stage.SeasonId == 0 {
+		season, err := models.GetCurrentSeason(h.Env.DB)
+		if err != nil {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "There is no current season"}, http.StatusBadRequest)
+			return
+		}
+		stage.SeasonId = season.Id
+	}
+
+	stage.Id = 0
+	if err := models.AddStage(h.Env.DB, &stage); err != nil {
+		respondWithStageError(w, r, err)
+		return
+	}
+
+	cached.InvalidateMatches()
+
+	respondWithJsonAndStatus(w, r, &requestResult{Status: "OK", Id: stage.Id}, http.StatusCreated)
+	log.Printf("Stage added: %+v", stage)
+}
+
+func (h *HttpHandlers) PutStage(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, ok := stageId(w, p)
+	if !ok {
+		return
+	}
+
+	old, err := models.LoadStage(h.Env.DB, id)
+	if err != nil {
+		respondWithStageError(w, r, err)
+		return
+	}
+
+	var stage models.Stage
+	if err := processBody(w, r, &stage); err != nil {
+		log.Printf("Can't process stage editing: %v", err)
+		return
+	}
+
+	stage.Id = id
+	if stage.SeasonId == 0 {
+		stage.SeasonId = old.SeasonId
+	}
+
+	if err := models.SaveStage(h.Env.DB, &stage); err != nil {
+		respondWithStageError(w, r, err)
+		return
+	}
+
+	cached.InvalidateMatches()
+
+	respondWithJson(w, r, &requestResult{Status: "OK", Id: id})
+	log.Printf("Stage saved: %+v", stage)
+}
+
+func (h *HttpHandlers) DeleteStage(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, ok := stageId(w, p)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteStage(h.Env.DB, id); err != nil {
+		respondWithStageError(w, r, err)
+		return
+	}
+
+	cached.InvalidateMatches()
+
+	respondWithJson(w, r, &requestResult{Status: "OK"})
+	log.Printf("Stage deleted: %d", id)
+}
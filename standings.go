@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aelnor/vangothrone/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+// GetStandings serves the team table of a stage if the "stage" parameter is
+// given, or of a whole season otherwise.
+func (h *HttpHandlers) GetStandings(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var matches []*models.Match
+
+	if param := r.URL.Query().Get("stage"); len(param) != 0 {
+		id, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Bad stage id"}, http.StatusBadRequest)
+			log.Printf("Bad stage id: %s", param)
+			return
+		}
+
+		stage, err := models.LoadStage(h.Env.DB, id)
+		if err == sql.ErrNoRows {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Stage is not found"}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Print("Can't load stage: ", err)
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load stage"}, http.StatusInternalServerError)
+			return
+		}
+
+		matches, err = models.LoadMatchesByStage(h.Env.DB, stage)
+		if err != nil {
+			log.Print("Can't load matches: ", err)
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load matches"}, http.StatusInternalServerError)
+			return
+		}
+	} else {
+		season, err := requestedSeason(w, r, h.Env.DB)
+		if err != nil {
+			log.Print("Can't scope standings: ", err)
+			return
+		}
+
+		matches, err = models.LoadMatchesBySeason(h.Env.DB, season.Id)
+		if err != nil {
+			log.Print("Can't load matches: ", err)
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load matches"}, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := respondWithJson(w, r, models.BuildStandings(matches)); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
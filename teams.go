@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aelnor/vangothrone/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+type jsonTeam struct {
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+	FunName string `json:"funName"`
+	Logo    string `json:"logo"`
+	Active  *bool  `json:"active"`
+}
+
+func (t *jsonTeam) toTeam(id int64) *models.Team {
+	team := &models.Team{
+		Id:      id,
+		Code:    t.Code,
+		Name:    t.Name,
+		FunName: t.FunName,
+		Logo:    t.Logo,
+		Active:  true,
+	}
+	if t.Active != nil {
+		team.Active = *t.Active
+	}
+	return team
+}
+
+func respondWithTeamError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case sql.ErrNoRows:
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Team is not found"}, http.StatusNotFound)
+	case models.ErrTeamCodeTaken, models.ErrTeamInUse:
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusConflict)
+	default:
+		log.Printf("Can't save team: %v", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't save team"}, http.StatusInternalServerError)
+	}
+}
+
+func teamId(w http.ResponseWriter, p httprouter.Params) (int64, bool) {
+	paramId := p.ByName("id")
+	id, err := strconv.ParseInt(paramId, 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		log.Printf("Bad team id: %s", paramId)
+		return 0, false
+	}
+	return id, true
+}
+
+func (h *HttpHandlers) GetTeams(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	teams, err := models.LoadTeams(h.Env.DB)
+	if err != nil {
+		log.Print("Can't load teams: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load teams"}, http.StatusInternalServerError)
+		return
+	}
+
+	if len(r.URL.Query().Get("season")) != 0 {
+		season, err := requestedSeason(w, r, h.Env.DB)
+		if err != nil {
+			log.Print("Can't scope teams: ", err)
+			return
+		}
+
+		codes, err := models.LoadSeasonTeams(h.Env.DB, season.Id)
+		if err != nil {
+			log.Print("Can't load season teams: ", err)
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load teams"}, http.StatusInternalServerError)
+			return
+		}
+
+		// seasons without a list of teams are open to every team
+		if len(codes) != 0 {
+			inSeason := make(map[string]bool)
+			for _, code := range codes {
+				inSeason[code] = true
+			}
+
+			seasonTeams := make([]*models.Team, 0, len(codes))
+			for _, t := range teams {
+				if inSeason[t.Code] {
+					seasonTeams = append(seasonTeams, t)
+				}
+			}
+			teams = seasonTeams
+		}
+	}
+
+	if err := respondWithJson(w, r, teams); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
+
+func (h *HttpHandlers) PostTeams(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var team jsonTeam
+	if err := processBody(w, r, &team); err != nil {
+		log.Printf("Can't process team adding: %v", err)
+		return
+	}
+
+	t := team.toTeam(0)
+	if len(t.Code) == 0 || len(t.Name) == 0 {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Team code and name are required"}, http.StatusBadRequest)
+		return
+	}
+
+	if err := models.AddTeam(h.Env.DB, t); err != nil {
+		respondWithTeamError(w, r, err)
+		return
+	}
+
+	respondWithJsonAndStatus(w, r, &requestResult{Status: "OK", Id: t.Id}, http.StatusCreated)
+	log.Printf("Team added: %+v", t)
+}
+
+func (h *HttpHandlers) PutTeam(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, ok := teamId(w, p)
+	if !ok {
+		return
+	}
+
+	var team jsonTeam
+	if err := processBody(w, r, &team); err != nil {
+		log.Printf("Can't process team editing: %v", err)
+		return
+	}
+
+	t := team.toTeam(id)
+	if len(t.Code) == 0 || len(t.Name) == 0 {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Team code and name are required"}, http.StatusBadRequest)
+		return
+	}
+
+	if err := models.SaveTeam(h.Env.DB, t); err != nil {
+		respondWithTeamError(w, r, err)
+		return
+	}
+
+	respondWithJson(w, r, &requestResult{Status: "OK", Id: t.Id})
+	log.Printf("Team saved: %+v", t)
+}
+
+func (h *HttpHandlers) DeleteTeam(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id, ok := teamId(w, p)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteTeam(h.Env.DB, id); err != nil {
+		respondWithTeamError(w, r, err)
+		return
+	}
+
+	respondWithJson(w, r, &requestResult{Status: "OK"})
+	log.Printf("Team deleted: %d", id)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aelnor/vangothrone/models"
+	"github.com/julienschmidt/httprouter"
+)
+
+const minPasswordLength = 6
+
+var loginRegexp = regexp.MustCompile(`^[a-z0-9_.-]{3,32}$`)
+
+func validatePassword(password string) string {
+	if len(password) < minPasswordLength {
+		return "Password is too short"
+	}
+	return ""
+}
+
+func (h *HttpHandlers) PostUsers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var jsonUser struct {
+		Login      string `json:"login"`
+		Name       string `json:"name"`
+		Password   string `json:"password"`
+		InviteCode string `json:"inviteCode"`
+	}
+
+	if err := processBody(w, r, &jsonUser); err != nil {
+		log.Printf("Can't process registration: %v", err)
+		return
+	}
+
+	mode, err := models.GetRegistrationMode(h.Env.DB)
+	if err != nil {
+		log.Printf("Can't load registration mode: %v", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't register user"}, http.StatusInternalServerError)
+		return
+	}
+
+	if mode == models.REGISTRATION_CLOSED {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Registration is closed"}, http.StatusForbidden)
+		return
+	}
+
+	u := &models.User{
+		Login: strings.ToLower(strings.TrimSpace(jsonUser.Login)),
+		Name:  strings.TrimSpace(jsonUser.Name),
+	}
+	if len(u.Name) == 0 {
+		u.Name = u.Login
+	}
+
+	if !loginRegexp.MatchString(u.Login) {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Login should be 3 to 32 latin letters, digits, '_', '.' or '-'"}, http.StatusBadRequest)
+		return
+	}
+	if text := validatePassword(jsonUser.Password); len(text) != 0 {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: text}, http.StatusBadRequest)
+		return
+	}
+
+	if mode == models.REGISTRATION_INVITE {
+		if err := models.ClaimInvite(h.Env.DB, jsonUser.InviteCode); err != nil {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusForbidden)
+			return
+		}
+	}
+
+	err = models.AddUser(h.Env.DB, u, jsonUser.Password)
+	if err != nil && mode == models.REGISTRATION_INVITE {
+		if err := models.ReleaseInvite(h.Env.DB, jsonUser.InviteCode); err != nil {
+			log.Printf("Can't release invite: %v", err)
+		}
+	}
+
+	switch {
+	case err == models.ErrLoginTaken:
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Can't add user: %v", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't register user"}, http.StatusInternalServerError)
+		return
+	}
+
+	if mode == models.REGISTRATION_INVITE {
+		if err := models.UseInvite(h.Env.DB, jsonUser.InviteCode, u.Id); err != nil {
+			log.Printf("Can't mark invite as used: %v", err)
+		}
+	}
+
+	respondWithJsonAndStatus(w, r, &requestResult{Status: "OK", Id: u.Id}, http.StatusCreated)
+	log.Printf("User registered: %s", u.Login)
+}
+
+func (h *HttpHandlers) PutMe(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user := currentUser(r)
+
+	var jsonUser struct {
+		Name            string `json:"name"`
+		Password        string `json:"password"`
+		CurrentPassword string `json:"currentPassword"`
+	}
+
+	if err := processBody(w, r, &jsonUser); err != nil {
+		log.Printf("Can't process profile editing: %v", err)
+		return
+	}
+
+	if len(jsonUser.Password) != 0 {
+		if text := validatePassword(jsonUser.Password); len(text) != 0 {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: text}, http.StatusBadRequest)
+			return
+		}
+		if _, err := models.CheckCredentials(h.Env.DB, user.Login, jsonUser.CurrentPassword); err != nil {
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Current password is incorrect"}, http.StatusForbidden)
+			return
+		}
+	}
+
+	name := strings.TrimSpace(jsonUser.Name)
+	if len(name) != 0 && name != user.Name {
+		if err := models.SaveUserName(h.Env.DB, user.Id, name); err != nil {
+			log.Printf("Can't save user name: %v", err)
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't save profile"}, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if len(jsonUser.Password) != 0 {
+		if err := models.SaveUserPassword(h.Env.DB, user.Id, jsonUser.Password); err != nil {
+			log.Printf("Can't save user password: %v", err)
+			respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't save profile"}, http.StatusInternalServerError)
+			return
+		}
+		// whoever knew the old password shouldn't stay logged in
+		if err := models.DeleteOtherSessions(h.Env.DB, user.Id, currentSession(r).Id); err != nil {
+			log.Printf("Can't revoke sessions: %v", err)
+		}
+	}
+
+	respondWithJson(w, r, &requestResult{Status: "OK", Id: user.Id})
+	log.Printf("Profile saved: %s", user.Login)
+}
+
+func (h *HttpHandlers) GetRegistration(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	mode, err := models.GetRegistrationMode(h.Env.DB)
+	if err != nil {
+		log.Print("Can't load registration mode: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load registration mode"}, http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJson(w, r, &struct {
+		Mode string `json:"mode"`
+	}{mode})
+}
+
+func (h *HttpHandlers) PutRegistration(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var jsonRegistration struct {
+		Mode string `json:"mode"`
+	}
+
+	if err := processBody(w, r, &jsonRegistration); err != nil {
+		log.Printf("Can't process registration mode: %v", err)
+		return
+	}
+
+	if err := models.SetRegistrationMode(h.Env.DB, jsonRegistration.Mode); err != nil {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	respondWithJson(w, r, &requestResult{Status: "OK"})
+	log.Printf("Registration mode set to %s", jsonRegistration.Mode)
+}
+
+func (h *HttpHandlers) GetInvites(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	invites, err := models.LoadInvites(h.Env.DB)
+	if err != nil {
+		log.Print("Can't load invites: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load invites"}, http.StatusInternalServerError)
+		return
+	}
+
+	if err := respondWithJson(w, r, invites); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
+
+func (h *HttpHandlers) PostInvites(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	inv := &models.Invite{CreatedBy: currentUser(r).Id}
+	if err := models.CreateInvite(h.Env.DB, inv); err != nil {
+		log.Printf("Can't create invite: %v", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't create invite"}, http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJsonAndStatus(w, r, inv, http.StatusCreated)
+}
+
+// GetUserStats shows how well the user predicts. Others see stats of
+// revealed predictions only, the user and admins see all of them.
+func (h *HttpHandlers) GetUserStats(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	paramId := p.ByName("id")
+	id, err := strconv.ParseInt(paramId, 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		log.Printf("Bad user id: %s", paramId)
+		return
+	}
+
+	users, err := models.LoadUsers(h.Env.DB)
+	if err != nil {
+		log.Print("Can't load users: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load users"}, http.StatusInternalServerError)
+		return
+	}
+	found := false
+	for _, u := range users {
+		if u.Id == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "User is not found"}, http.StatusNotFound)
+		return
+	}
+
+	viewer := currentUser(r)
+	all := viewer != nil && (viewer.Id == id || viewer.IsAdmin)
+	stats, err := models.LoadUserStats(h.Env.DB, id, all)
+	if err != nil {
+		log.Print("Can't compute user stats: ", err)
+		respondWithJsonAndStatus(w, r, &requestResult{Status: "Fail", Text: "Can't load predictions"}, http.StatusInternalServerError)
+		return
+	}
+
+	if err := respondWithJson(w, r, stats); err != nil {
+		log.Print("Can't send response: ", err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -22,18 +23,51 @@ func InitEnvironment() (*config.Env, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Can't init table: %s", err.Error())
 	}
+	if err := models.InitSettingsTable(db); err != nil {
+		return nil, fmt.Errorf("Can't init table 'Settings': %s", err.Error())
+	}
+	if err := models.InitSeasonsTable(db); err != nil {
+		return nil, fmt.Errorf("Can't init table 'Seasons': %s", err.Error())
+	}
 	if err := models.InitUsersTable(db); err != nil {
 		return nil, fmt.Errorf("Can't init table 'Users': %s", err.Error())
 	}
+	if err := models.InitInvitesTable(db); err != nil {
+		return nil, fmt.Errorf("Can't init table 'Invites': %s", err.Error())
+	}
+	if err := models.InitSessionsTable(db); err != nil {
+		return nil, fmt.Errorf("Can't init table 'Sessions': %s", err.Error())
+	}
+	if err := models.InitTeamsTable(db); err != nil {
+		return nil, fmt.Errorf("Can't init table 'Teams': %s", err.Error())
+	}
 	if err := models.InitMatchesTable(db); err != nil {
 		return nil, fmt.Errorf("Can't init table 'Matches': %s", err.Error())
 	}
 	if err := models.InitPredictionsTable(db); err != nil {
 		return nil, fmt.Errorf("Can't init table 'Predictions': %s", err.Error())
 	}
+	if err := models.InitPredictionHistoryTable(db); err != nil {
+		return nil, fmt.Errorf("Can't init table 'PredictionHistory': %s", err.Error())
+	}
+	if err := models.InitMapsTables(db); err != nil {
+		return nil, fmt.Errorf("Can't init tables 'MatchMaps': %s", err.Error())
+	}
 	if err := models.InitStagesTable(db); err != nil {
 		return nil, fmt.Errorf("Can't init table 'Stages': %s", err.Error())
 	}
+	if err := models.InitLeaguesTables(db); err != nil {
+		return nil, fmt.Errorf("Can't init tables 'Leagues': %s", err.Error())
+	}
+	if err := models.InitScoringRulesTable(db); err != nil {
+		return nil, fmt.Errorf("Can't init table 'ScoringRules': %s", err.Error())
+	}
+	if err := models.InitFuturesTables(db); err != nil {
+		return nil, fmt.Errorf("Can't init tables 'FutureMarkets': %s", err.Error())
+	}
+	if err := models.InitUserAchievementsTable(db); err != nil {
+		return nil, fmt.Errorf("Can't init table 'UserAchievements': %s", err.Error())
+	}
 	log.Printf("Database Initialized")
 
 	env := &config.Env{
@@ -42,40 +76,75 @@ func InitEnvironment() (*config.Env, error) {
 	return env, nil
 }
 
-func teamsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Pragma", "no-cache")
-	w.Header().Set("Cache-Control", "no-cache,must-revalidate")
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	jsontext, err := json.Marshal(models.Teams)
-
-	if err != nil {
-		fmt.Printf("Can't marshal teams: %v", err)
-		return
-	}
-
-	fmt.Fprintf(w, string(jsontext))
-}
-
 func main() {
+	recompute := flag.Bool("recompute", false, "replay all predictions against match results and exit")
+	flag.Parse()
+
 	env, err := InitEnvironment()
 	if err != nil {
 		log.Fatal("Can't init environment: ", err)
 	}
+
+	if *recompute {
+		if err := models.RecomputePoints(env.DB); err != nil {
+			log.Fatal("Can't recompute points: ", err)
+		}
+		log.Printf("Points recomputed")
+		return
+	}
+
 	hh := &HttpHandlers{Env: env}
 
 	rtr := httprouter.New()
-	rtr.GET("/teams", teamsHandler)
-	rtr.GET("/matches", hh.GetMatches)
-	rtr.POST("/matches", hh.PostMatches)
-	rtr.PUT("/predictions", hh.PutPredictions)
-	rtr.PUT("/matches/:id", hh.PutMatch)
+	rtr.GET("/teams", hh.GetTeams)
+	rtr.POST("/teams", hh.authorize(roleAdmin, hh.PostTeams))
+	rtr.PUT("/teams/:id", hh.authorize(roleAdmin, hh.PutTeam))
+	rtr.DELETE("/teams/:id", hh.authorize(roleAdmin, hh.DeleteTeam))
+	rtr.GET("/matches", hh.authorize(roleUser, hh.GetMatches))
+	rtr.GET("/matches/:id", hh.authorize(roleUser, hh.GetMatch))
+	rtr.GET("/matches/:id/stats", hh.authorize(roleUser, hh.GetMatchStats))
+	rtr.POST("/matches", hh.authorize(roleAdmin, hh.PostMatches))
+	rtr.PUT("/predictions", hh.authorize(roleUser, hh.PutPredictions))
+	rtr.PUT("/predictions/batch", hh.authorize(roleUser, hh.PutPredictionsBatch))
+	rtr.GET("/predictions/history", hh.authorize(roleUser, hh.GetPredictionsHistory))
+	rtr.PUT("/matches/:id", hh.authorize(roleAdmin, hh.PutMatch))
+	rtr.DELETE("/matches/:id", hh.authorize(roleAdmin, hh.DeleteMatch))
 	rtr.POST("/login", hh.PostLogin)
 	rtr.GET("/login", hh.GetLogin)
 	rtr.GET("/logout", hh.GetLogout)
+	rtr.GET("/sessions", hh.authorize(roleUser, hh.GetSessions))
+	rtr.DELETE("/sessions/:id", hh.authorize(roleUser, hh.DeleteSession))
 	rtr.GET("/users", hh.GetUsers)
+	rtr.POST("/users", hh.PostUsers)
+	rtr.PUT("/users/me", hh.authorize(roleUser, hh.PutMe))
+	rtr.GET("/users/:id/stats", hh.authorize(roleAnonymous, hh.GetUserStats))
+	rtr.GET("/registration", hh.GetRegistration)
+	rtr.PUT("/registration", hh.authorize(roleAdmin, hh.PutRegistration))
+	rtr.GET("/invites", hh.authorize(roleAdmin, hh.GetInvites))
+	rtr.POST("/invites", hh.authorize(roleAdmin, hh.PostInvites))
+	rtr.GET("/seasons", hh.GetSeasons)
+	rtr.POST("/seasons", hh.authorize(roleAdmin, hh.PostSeasons))
+	rtr.PUT("/seasons/:id", hh.authorize(roleAdmin, hh.PutSeason))
+	rtr.PUT("/seasons/:id/teams", hh.authorize(roleAdmin, hh.PutSeasonTeams))
 	rtr.GET("/stages", hh.GetStages)
+	rtr.GET("/stages/:id", hh.GetStage)
+	rtr.POST("/stages", hh.authorize(roleAdmin, hh.PostStages))
+	rtr.PUT("/stages/:id", hh.authorize(roleAdmin, hh.PutStage))
+	rtr.DELETE("/stages/:id", hh.authorize(roleAdmin, hh.DeleteStage))
+	rtr.GET("/stages/:id/leaderboard", hh.authorize(roleAnonymous, hh.GetStageLeaderboard))
+	rtr.GET("/leaderboard", hh.authorize(roleAnonymous, hh.GetLeaderboard))
+	rtr.GET("/standings", hh.GetStandings)
+	rtr.GET("/leagues", hh.authorize(roleUser, hh.GetLeagues))
+	rtr.POST("/leagues", hh.authorize(roleUser, hh.PostLeagues))
+	rtr.POST("/leagues/join", hh.authorize(roleUser, hh.PostLeaguesJoin))
+	rtr.PUT("/leagues/:id", hh.authorize(roleUser, hh.PutLeague))
+	rtr.GET("/scoring-rules", hh.authorize(roleAdmin, hh.GetScoringRules))
+	rtr.PUT("/scoring-rules", hh.authorize(roleAdmin, hh.PutScoringRules))
+	rtr.GET("/futures", hh.authorize(roleUser, hh.GetFutures))
+	rtr.POST("/futures", hh.authorize(roleAdmin, hh.PostFutures))
+	rtr.PUT("/futures/:id/pick", hh.authorize(roleUser, hh.PutFuturePick))
+	rtr.PUT("/futures/:id/settle", hh.authorize(roleAdmin, hh.PutFutureSettle))
+	rtr.GET("/achievements", hh.GetAchievements)
 
 	rtr.GET("/", hh.GetIndex)
 	rtr.ServeFiles("/static/*filepath", http.Dir(config.GetStaticPath()+"static/"))
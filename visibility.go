@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/aelnor/vangothrone/models"
+)
+
+// visibility decides what a user may see of predictions and picks of other
+// users. Until they are revealed only the fact that they exist is shown,
+// and for members of leagues hiding predictors not even that.
+type visibility struct {
+	viewer           *models.User
+	hiddenPredictors map[int64]bool
+}
+
+func newVisibility(db *sql.DB, viewer *models.User) (*visibility, error) {
+	hidden, err := models.LoadHiddenPredictors(db)
+	if err != nil {
+		return nil, err
+	}
+	return &visibility{viewer: viewer, hiddenPredictors: hidden}, nil
+}
+
+// visible tells whether the viewer may know that the user has predicted something not yet revealed.
+func (v *visibility) visible(userId int64, revealed bool) bool {
+	return revealed || userId == v.viewer.Id || !v.hiddenPredictors[userId]
+}
+
+// prediction returns a copy of the prediction fit for the viewer, nil if the
+// viewer shouldn't know about it at all.
+func (v *visibility) prediction(pred *models.Prediction, revealed bool) *models.Prediction {
+	if !v.visible(pred.UserId, revealed) {
+		return nil
+	}
+
+	result := *pred
+	if !revealed && pred.UserId != v.viewer.Id {
+		result = models.Prediction{UserId: pred.UserId, MatchId: pred.MatchId, Hidden: true}
+	}
+	return &result
+}
+
+// pick returns a copy of the pick fit for the viewer, nil if the viewer
+// shouldn't know about it at all.
+func (v *visibility) pick(pick *models.FuturePick, revealed bool) *models.FuturePick {
+	if !v.visible(pick.UserId, revealed) {
+		return nil
+	}
+
+	result := *pick
+	if !revealed && pick.UserId != v.viewer.Id {
+		result = models.FuturePick{UserId: pick.UserId, MarketId: pick.MarketId, Hidden: true}
+	}
+	return &result
+}